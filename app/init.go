@@ -2,7 +2,7 @@
 package app
 
 import (
-	"os"
+	"strconv"
 
 	"github.com/kataras/iris/v12"
 	"github.com/scjtqs2/bot_adapter/client"
@@ -11,12 +11,14 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 
+	"github.com/scjtqs2/bot_app_github/config"
 	"github.com/scjtqs2/bot_app_github/search"
 	"github.com/scjtqs2/bot_app_github/webhook"
 )
 
 // App 结构体
 type App struct {
+	cfg              *config.Config
 	appID            string
 	appSecret        string
 	appEncryptKey    string
@@ -26,13 +28,14 @@ type App struct {
 	hook             *webhook.GHook
 }
 
-// NewApp 初始化app
-func NewApp() *App {
+// NewApp 按配置初始化app
+func NewApp(cfg *config.Config) *App {
 	return &App{
-		appID:          os.Getenv("APP_ID"),
-		appSecret:      os.Getenv("APP_SECRET"),
-		appEncryptKey:  os.Getenv("APP_ENCRYPT_KEY"),
-		botAdapterAddr: os.Getenv("ADAPTER_ADDR"),
+		cfg:            cfg,
+		appID:          cfg.App.ID,
+		appSecret:      cfg.App.Secret,
+		appEncryptKey:  cfg.App.EncryptKey,
+		botAdapterAddr: cfg.App.AdapterAddr,
 	}
 }
 
@@ -46,20 +49,29 @@ func (a *App) Init() {
 	app := iris.New()
 	app.Post("/", a.msginput)
 	go func() {
-		port := "8080"
-		if os.Getenv("HTTP_PORT") != "" {
-			port = os.Getenv("HTTP_PORT")
-		}
+		port := strconv.Itoa(a.cfg.App.HTTPPort)
 		err = app.Run(iris.Addr(":" + port))
 		if err != nil {
 			log.Fatalf("error init http listen port %s err:%v", port, err)
 		}
 	}()
-	a.search = search.NewGSearch(a.botAdapterClient)
-	a.hook = webhook.NewGHook(a.botAdapterClient)
+	a.hook = webhook.NewGHook(a.botAdapterClient, a.cfg)
+	a.search = search.NewGSearch(a.botAdapterClient, a.hook.Subscriptions, a.hook.Cache)
 	a.hook.Init()
 }
 
+// Reload 热加载配置，目前支持 webhook 的路由规则、消息模板和推送目标
+func (a *App) Reload(cfg *config.Config) error {
+	if a.hook == nil {
+		return nil
+	}
+	if err := a.hook.Reload(cfg.Webhook); err != nil {
+		return err
+	}
+	a.cfg = cfg
+	return nil
+}
+
 func (a *App) msginput(ctx iris.Context) {
 	raw, _ := ctx.GetBody()
 	enc := gjson.ParseBytes(raw).Get("encrypt").String()
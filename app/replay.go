@@ -0,0 +1,51 @@
+package app
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/scjtqs2/bot_adapter/client"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/scjtqs2/bot_app_github/webhook"
+)
+
+// Replay replay 子命令，按投递id或时间范围重新推送事件日志中的event
+//
+//	bot_app replay -id 72d3162e-cc78-11e3-81ab-4c9367dc0958
+//	bot_app replay -since "2022-02-10 00:00:00" -until "2022-02-11 00:00:00"
+func (a *App) Replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	id := flags.String("id", "", "X-GitHub-Delivery")
+	since := flags.String("since", "", "开始时间，RFC3339 或 \"2006-01-02 15:04:05\"")
+	until := flags.String("until", "", "结束时间，RFC3339 或 \"2006-01-02 15:04:05\"")
+	_ = flags.Parse(args)
+	if *id == "" && *since == "" && *until == "" {
+		flags.Usage()
+		return errors.New("one of -id, -since, -until is required")
+	}
+	filter := &webhook.ReplayFilter{DeliveryID: *id}
+	var err error
+	if filter.Since, err = webhook.ParseReplayTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = webhook.ParseReplayTime(*until); err != nil {
+		return err
+	}
+	a.botAdapterClient, err = client.NewAdapterServiceClient(a.botAdapterAddr, a.appID, a.appSecret)
+	if err != nil {
+		return err
+	}
+	// 服务可能正在写入事件日志，replay 只读打开
+	cfg := *a.cfg
+	cfg.Webhook.EventLog = ""
+	a.hook = webhook.NewGHook(a.botAdapterClient, &cfg)
+	if a.cfg.Webhook.EventLog != "" {
+		if a.hook.EventLog, err = webhook.OpenEventLogReadOnly(a.cfg.Webhook.EventLog); err != nil {
+			return err
+		}
+	}
+	n, err := a.hook.Replay(filter)
+	log.Infof("replayed %d deliveries", n)
+	return err
+}
@@ -0,0 +1,281 @@
+// Package config 应用配置，从 yaml 配置文件加载，环境变量覆盖配置文件中的值
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 应用的全部配置
+type Config struct {
+	App        App        `yaml:"app"`
+	Webhook    Webhook    `yaml:"webhook"`
+	Screenshot Screenshot `yaml:"screenshot"`
+}
+
+// App bot-adapter 应用的配置
+type App struct {
+	ID          string `yaml:"id" env:"APP_ID"`
+	Secret      string `yaml:"secret" env:"APP_SECRET"`
+	EncryptKey  string `yaml:"encrypt_key" env:"APP_ENCRYPT_KEY"` // 推送解密的密码
+	AdapterAddr string `yaml:"adapter_addr" env:"ADAPTER_ADDR"`   // bot-adapter 的 grpc 地址
+	HTTPPort    int    `yaml:"http_port" env:"HTTP_PORT"`         // 接收 bot-adapter 推送的端口
+}
+
+// Webhook webhook 推送的配置，Routes、Templates 和推送目标支持 SIGHUP 热加载
+type Webhook struct {
+	Enable            bool          `yaml:"enable" env:"GITHUB_WEBHOOK_ENABLE"`
+	Secret            string        `yaml:"secret" env:"GITHUB_WEBHOOK_SECRET"`                         // github 的hook的secret，多个用逗号分隔
+	AllowSHA1         bool          `yaml:"allow_sha1" env:"GITHUB_WEBHOOK_ALLOW_SHA1"`                 // 是否允许已废弃的sha1签名校验
+	AdminQQ           int64         `yaml:"admin_qq" env:"GITHUB_WEBHOOK_ADMIN_QQ"`                     // 管理员qq
+	NotifyQQ          int64         `yaml:"notify_qq" env:"GITHUB_WEBHOOK_NOTIFY_QQ"`                   // 全部推送给该qq
+	NotifyGroup       int64         `yaml:"notify_group" env:"GITHUB_WEBHOOK_NOTIFY_GROUP"`             // 已废弃，第一次启动时迁移为群订阅
+	Subscriptions     string        `yaml:"subscriptions" env:"GITHUB_WEBHOOK_SUBSCRIPTIONS"`           // 群订阅的保存文件
+	Routes            string        `yaml:"routes" env:"GITHUB_WEBHOOK_ROUTES"`                         // 推送路由表的json文件
+	Templates         string        `yaml:"templates" env:"GITHUB_WEBHOOK_TEMPLATES"`                   // 推送消息模板目录
+	PushBranches      []string      `yaml:"push_branches" env:"GITHUB_WEBHOOK_PUSH_BRANCHES"`           // 推送push事件的分支，环境变量用逗号分隔
+	PushCommits       int           `yaml:"push_commits" env:"GITHUB_WEBHOOK_PUSH_COMMITS"`             // push 消息最多列出的 commit 数
+	PushTags          bool          `yaml:"push_tags" env:"GITHUB_WEBHOOK_PUSH_TAGS"`                   // 是否推送tag的push
+	ReleaseScreenshot bool          `yaml:"release_screenshot" env:"GITHUB_WEBHOOK_RELEASE_SCREENSHOT"` // release 消息是否附带发布页截图
+	DedupSize         int           `yaml:"dedup_size" env:"GITHUB_WEBHOOK_DEDUP_SIZE"`
+	DedupTTL          time.Duration `yaml:"dedup_ttl" env:"GITHUB_WEBHOOK_DEDUP_TTL"`
+	DedupFile         string        `yaml:"dedup_file" env:"GITHUB_WEBHOOK_DEDUP_FILE"`
+	EventLog          string        `yaml:"event_log" env:"GITHUB_WEBHOOK_EVENT_LOG"` // 事件日志目录
+	EventLogSegments  int           `yaml:"event_log_segments" env:"GITHUB_WEBHOOK_EVENT_LOG_SEGMENTS"`
+	CIFailureOnly     bool          `yaml:"ci_failure_only" env:"GITHUB_WEBHOOK_CI_FAILURE_ONLY"`
+	CIStateFile       string        `yaml:"ci_state_file" env:"GITHUB_WEBHOOK_CI_STATE_FILE"`
+	GitlabPath        string        `yaml:"gitlab_path" env:"GITLAB_WEBHOOK_PATH"`
+	GitlabToken       string        `yaml:"gitlab_token" env:"GITLAB_WEBHOOK_TOKEN"`
+	GiteaPath         string        `yaml:"gitea_path" env:"GITEA_WEBHOOK_PATH"`
+	GiteaSecret       string        `yaml:"gitea_secret" env:"GITEA_WEBHOOK_SECRET"`
+}
+
+// Screenshot 截图的配置
+type Screenshot struct {
+	Renderer   string        `yaml:"renderer" env:"SCREENSHOT_RENDERER"` // selenium-chrome、selenium-firefox、chromedp，为空时按 selenium 的开关选择
+	Selenium   Selenium      `yaml:"selenium"`
+	Chromedp   Chromedp      `yaml:"chromedp"`
+	Card       bool          `yaml:"card" env:"SCREENSHOT_CARD"`           // 没有截图时是否画成卡片
+	CardFont   string        `yaml:"card_font" env:"SCREENSHOT_CARD_FONT"` // 画卡片用的字体文件
+	CacheDir   string        `yaml:"cache_dir" env:"SCREENSHOT_CACHE_DIR"`
+	CacheMaxMB int64         `yaml:"cache_max_mb" env:"SCREENSHOT_CACHE_MAX_MB"`
+	Wait       time.Duration `yaml:"wait" env:"SCREENSHOT_WAIT"` // 推送前等待截图的时间
+	Width      int           `yaml:"width" env:"SCREENSHOT_WIDTH"`
+	Height     int           `yaml:"height" env:"SCREENSHOT_HEIGHT"`
+	Scale      float64       `yaml:"scale" env:"SCREENSHOT_SCALE"`
+	Theme      string        `yaml:"theme" env:"SCREENSHOT_THEME"`           // light、dark，为空时使用浏览器默认
+	MaxHeight  int           `yaml:"max_height" env:"SCREENSHOT_MAX_HEIGHT"` // 单张图片的最大高度，0表示不切
+	Rules      string        `yaml:"rules" env:"SCREENSHOT_RULES"`           // 截图规则的json文件
+	Cookie     string        `yaml:"cookie" env:"SCREENSHOT_COOKIE"`         // github 登录 cookie
+	ProfileDir string        `yaml:"profile_dir" env:"SCREENSHOT_PROFILE_DIR"`
+}
+
+// Selenium selenium 截图后端的配置
+type Selenium struct {
+	ChromeEnable  bool          `yaml:"chrome_enable" env:"SELENIUM_CHROME_ENABLE"` // 兼容旧配置，renderer 为空时生效
+	ChromeAddr    string        `yaml:"chrome_addr" env:"SELENIUM_CHROME_ADDR"`
+	FirefoxEnable bool          `yaml:"firefox_enable" env:"SELENIUM_FIREFOX_ENABLE"` // 兼容旧配置，renderer 为空时生效，优先于 chrome
+	FirefoxAddr   string        `yaml:"firefox_addr" env:"SELENIUM_FIREFOX_ADDR"`
+	PoolSize      int           `yaml:"pool_size" env:"SELENIUM_POOL_SIZE"` // 同时使用的会话数，也是截图的 worker 数
+	IdleTimeout   time.Duration `yaml:"idle_timeout" env:"SELENIUM_IDLE_TIMEOUT"`
+}
+
+// Chromedp chromedp 截图后端的配置
+type Chromedp struct {
+	Addr string `yaml:"addr" env:"CHROMEDP_ADDR"` // chrome 的远程调试地址，为空时在本机启动
+	Exec string `yaml:"exec" env:"CHROMEDP_EXEC"` // 本机启动的 chrome 可执行文件
+}
+
+// 支持的截图后端，和 webhook 包中的 Renderer* 常量一致
+var renderers = []string{"", "selenium-chrome", "selenium-firefox", "chromedp"}
+
+// Default 默认配置
+func Default() *Config {
+	return &Config{
+		App: App{HTTPPort: 8080},
+		Webhook: Webhook{
+			Subscriptions: "subscriptions.json",
+			PushCommits:   5,
+			DedupSize:     1000,
+			DedupTTL:      24 * time.Hour,
+		},
+		Screenshot: Screenshot{
+			Selenium: Selenium{
+				PoolSize:    2,
+				IdleTimeout: time.Minute,
+			},
+			Card:       true,
+			CacheMaxMB: 100,
+			Wait:       3 * time.Second,
+			Width:      600,
+			Height:     812,
+			Scale:      1,
+		},
+	}
+}
+
+// Load 加载配置：默认配置，再用配置文件覆盖，再用不为空的环境变量覆盖，最后校验。
+// file 为空时只读取环境变量
+func Load(file string) (*Config, error) {
+	cfg := Default()
+	if file != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		// 配置项写错时报错，不静默忽略
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			errmsg := fmt.Sprintf("parse %s: %v", file, err)
+			return nil, errors.New(errmsg)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	cfg.Screenshot.Renderer = strings.ToLower(strings.TrimSpace(cfg.Screenshot.Renderer))
+	cfg.Screenshot.Theme = strings.ToLower(strings.TrimSpace(cfg.Screenshot.Theme))
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// durationType time.Duration 的类型，按 1m、30s 的格式解析
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv 按字段的 env 标签，用不为空的环境变量覆盖配置
+func applyEnv(v reflect.Value) error {
+	var errs []string
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(value); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		env := strings.TrimSpace(os.Getenv(key))
+		if env == "" {
+			continue
+		}
+		if err := setValue(value, env); err != nil {
+			errs = append(errs, fmt.Sprintf("env %s=%q: %v", key, env, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// setValue 把环境变量的字符串解析成字段的类型
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
+
+// Validate 校验配置，返回所有的错误
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	exists := func(name, file string) {
+		if file == "" {
+			return
+		}
+		_, err := os.Stat(file)
+		check(err == nil, "%s: %v", name, err)
+	}
+	check(c.App.HTTPPort > 0 && c.App.HTTPPort < 65536, "app.http_port %d out of range", c.App.HTTPPort)
+	w := c.Webhook
+	check(w.PushCommits > 0, "webhook.push_commits must be positive")
+	check(w.DedupSize > 0, "webhook.dedup_size must be positive")
+	check(w.DedupTTL > 0, "webhook.dedup_ttl must be positive")
+	check(w.EventLogSegments >= 0, "webhook.event_log_segments must not be negative")
+	check(w.Subscriptions != "", "webhook.subscriptions is required")
+	exists("webhook.routes", w.Routes)
+	exists("webhook.templates", w.Templates)
+	s := c.Screenshot
+	check(contains(renderers, s.Renderer), "screenshot.renderer %q must be one of %s", s.Renderer, strings.Join(renderers[1:], ", "))
+	check(s.Theme == "" || s.Theme == "light" || s.Theme == "dark", "screenshot.theme %q must be light or dark", s.Theme)
+	check(s.Selenium.PoolSize > 0, "screenshot.selenium.pool_size must be positive")
+	check(s.Selenium.IdleTimeout >= 0, "screenshot.selenium.idle_timeout must not be negative")
+	check(s.Width > 0 && s.Height > 0, "screenshot.width and screenshot.height must be positive")
+	check(s.Scale > 0, "screenshot.scale must be positive")
+	check(s.MaxHeight >= 0, "screenshot.max_height must not be negative")
+	check(s.CacheMaxMB > 0, "screenshot.cache_max_mb must be positive")
+	check(s.Wait >= 0, "screenshot.wait must not be negative")
+	exists("screenshot.rules", s.Rules)
+	exists("screenshot.card_font", s.CardFont)
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// contains 字符串是否在列表中
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig 写入临时的配置文件
+func writeConfig(t *testing.T, text string) string {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(text), 0o644); err != nil {
+		t.Fatalf("write config err %v", err)
+	}
+	return file
+}
+
+// TestLoad 测试默认值、配置文件和环境变量的优先级
+func TestLoad(t *testing.T) {
+	file := writeConfig(t, `
+app:
+  adapter_addr: bot-adapter:8001
+webhook:
+  enable: true
+  admin_qq: 10001
+  push_branches: [main, release/*]
+  push_commits: 10
+  dedup_ttl: 1h
+screenshot:
+  renderer: ChromeDP
+  selenium:
+    pool_size: 4
+  wait: 0s
+`)
+	t.Setenv("GITHUB_WEBHOOK_PUSH_COMMITS", "20")
+	t.Setenv("GITHUB_WEBHOOK_ADMIN_QQ", "")
+	t.Setenv("SCREENSHOT_CARD", "false")
+	t.Setenv("SELENIUM_IDLE_TIMEOUT", "30s")
+	cfg, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load err %v", err)
+	}
+	w := cfg.Webhook
+	if !w.Enable || w.AdminQQ != 10001 || w.PushCommits != 20 || w.DedupTTL != time.Hour || w.DedupSize != 1000 || strings.Join(w.PushBranches, ",") != "main,release/*" {
+		t.Errorf("Load() webhook = %+v", w)
+	}
+	s := cfg.Screenshot
+	if s.Renderer != "chromedp" || s.Selenium.PoolSize != 4 || s.Selenium.IdleTimeout != 30*time.Second || s.Card || s.Wait != 0 || s.Width != 600 {
+		t.Errorf("Load() screenshot = %+v", s)
+	}
+	if cfg.App.AdapterAddr != "bot-adapter:8001" || cfg.App.HTTPPort != 8080 {
+		t.Errorf("Load() app = %+v", cfg.App)
+	}
+
+	t.Setenv("GITHUB_WEBHOOK_PUSH_BRANCHES", "dev, feature/*")
+	if cfg, err := Load(""); err != nil || strings.Join(cfg.Webhook.PushBranches, ",") != "dev,feature/*" {
+		t.Errorf("Load() push_branches = %v, %v", cfg.Webhook.PushBranches, err)
+	}
+}
+
+// TestLoadErrors 测试配置写错时启动报错
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		env  map[string]string
+		want []string
+	}{
+		{"unknown field", "webhook:\n  enabled: true\n", nil, []string{"enabled"}},
+		{"invalid env", "", map[string]string{"GITHUB_WEBHOOK_ENABLE": "yes", "SCREENSHOT_WAIT": "3"}, []string{"GITHUB_WEBHOOK_ENABLE", "SCREENSHOT_WAIT"}},
+		{"invalid values", "webhook:\n  push_commits: 0\n  routes: /not/exists.json\nscreenshot:\n  renderer: phantomjs\n  theme: solarized\n", nil, []string{"push_commits", "webhook.routes", "phantomjs", "solarized"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := Load(writeConfig(t, tt.text))
+			if err == nil {
+				t.Fatalf("Load() should fail")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() err = %v, want %s", err, want)
+				}
+			}
+		})
+	}
+}
@@ -2,17 +2,52 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/scjtqs2/bot_app_github/app"
+	"github.com/scjtqs2/bot_app_github/config"
 )
 
 func main() {
-	newApp := app.NewApp()
+	file := flag.String("config", os.Getenv("CONFIG_FILE"), "yaml 配置文件，环境变量会覆盖其中的配置")
+	flag.Parse()
+	cfg, err := config.Load(*file)
+	if err != nil {
+		log.Fatalf("load config err:%v", err)
+	}
+	newApp := app.NewApp(cfg)
+	if args := flag.Args(); len(args) > 0 && args[0] == "replay" {
+		if err := newApp.Replay(args[1:]); err != nil {
+			log.Fatalf("replay err:%v", err)
+		}
+		return
+	}
 	newApp.Init()
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
-	<-quit
-	os.Exit(1)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for {
+		select {
+		case <-hup:
+			// 重新加载配置文件，失败时继续使用原来的配置
+			cfg, err := config.Load(*file)
+			if err != nil {
+				log.Errorf("reload config err:%v", err)
+				continue
+			}
+			if err := newApp.Reload(cfg); err != nil {
+				log.Errorf("reload config err:%v", err)
+				continue
+			}
+			log.Info("config reloaded")
+		case <-quit:
+			os.Exit(1)
+		}
+	}
 }
@@ -3,6 +3,7 @@ package search
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -15,18 +16,25 @@ import (
 	"github.com/scjtqs2/bot_adapter/coolq"
 	"github.com/scjtqs2/bot_adapter/event"
 	"github.com/scjtqs2/bot_adapter/pb/entity"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
+
+	"github.com/scjtqs2/bot_app_github/webhook"
 )
 
 // GSearch github search 服务
 type GSearch struct {
-	Cli *client.AdapterService
+	Cli   *client.AdapterService
+	Subs  *webhook.Subscriptions   // 群订阅，用于 #github sub/unsub/subs 命令
+	Cache *webhook.ScreenshotCache // 仓库图片的缓存，为nil时直接发送图片地址
 }
 
 // NewGSearch 初始化 gsearch服务
-func NewGSearch(cli *client.AdapterService) *GSearch {
+func NewGSearch(cli *client.AdapterService, subs *webhook.Subscriptions, cache *webhook.ScreenshotCache) *GSearch {
 	return &GSearch{
-		Cli: cli,
+		Cli:   cli,
+		Subs:  subs,
+		Cache: cache,
 	}
 }
 
@@ -46,7 +54,12 @@ func (g *GSearch) SearchPrivate(req event.MessagePrivate) {
 func (g *GSearch) SearchGroup(req event.MessageGroup) {
 	searchType, keyword, ok := g.parseKeyword(req.RawMessage)
 	if ok {
-		msg := g.searchText(searchType, keyword)
+		var msg string
+		if cmd, args, isSub := parseSubCommand(searchType, keyword); isSub {
+			msg = g.subCommand(req, cmd, args)
+		} else {
+			msg = g.searchText(searchType, keyword)
+		}
 		_, _ = g.Cli.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
 			GroupId: req.GroupID,
 			Message: []byte(msg),
@@ -87,7 +100,7 @@ func (g *GSearch) searchText(searchType, keyword string) string {
 	var msg string
 	switch searchType {
 	case "-p": // 图片模式
-		msg = coolq.EnImageCode("https://opengraph.githubassets.com/0/"+repo.Get("full_name").String(), 0)
+		msg = g.repoImage(repo, header)
 	case "-t":
 		msg = fmt.Sprintf("%s\n"+
 			"Description: "+
@@ -129,11 +142,27 @@ func (g *GSearch) searchText(searchType, keyword string) string {
 			notnull(repo.Get("language").String(), "None"),
 			notnull(repo.Get("license.key").String(), "None"),
 			repo.Get("pushed_at").String(),
-			repo.Get("html_url").String()) + coolq.EnImageCode("https://opengraph.githubassets.com/0/"+repo.Get("full_name").String(), 0)
+			repo.Get("html_url").String()) + g.repoImage(repo, header)
 	}
 	return msg
 }
 
+// repoImage 仓库的 opengraph 图片。开启了缓存时下载下来缓存，仓库更新前重复查询直接用缓存的图片
+func (g *GSearch) repoImage(repo gjson.Result, header http.Header) string {
+	img := "https://opengraph.githubassets.com/0/" + repo.Get("full_name").String()
+	if g.Cache == nil {
+		return coolq.EnImageCode(img, 0)
+	}
+	pic, err := g.Cache.GetOrRender(webhook.CacheKey(img, repo.Get("updated_at").String()), func() ([]byte, error) {
+		return netGet(img, header)
+	})
+	if err != nil {
+		log.Warnf("download %s err:%v", img, err)
+		return coolq.EnImageCode(img, 0)
+	}
+	return coolq.EnImageCode("base64://"+base64.StdEncoding.EncodeToString(pic), 0)
+}
+
 // notnull 如果传入文本为空，则返回默认值
 //nolint: unparam
 func notnull(text, defstr string) string {
@@ -0,0 +1,87 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scjtqs2/bot_adapter/event"
+)
+
+// repoPattern owner/repo，允许 glob 通配符
+var repoPattern = regexp.MustCompile(`^[\w.*?\[\]-]+/[\w.*?\[\]-]+$`)
+
+// parseSubCommand 判断是否是订阅相关的命令: sub、unsub、subs
+func parseSubCommand(searchType, keyword string) (string, []string, bool) {
+	if searchType != "" {
+		return "", nil, false
+	}
+	fields := strings.Fields(keyword)
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	switch fields[0] {
+	case "subs":
+		if len(fields) == 1 {
+			return fields[0], nil, true
+		}
+	case "sub":
+		if len(fields) >= 2 {
+			return fields[0], fields[1:], true
+		}
+	case "unsub":
+		if len(fields) == 2 {
+			return fields[0], fields[1:], true
+		}
+	}
+	return "", nil, false
+}
+
+// subCommand 处理群内的订阅命令
+func (g *GSearch) subCommand(req event.MessageGroup, cmd string, args []string) string {
+	if g.Subs == nil {
+		return "ERROR: 订阅功能未开启"
+	}
+	if cmd == "subs" {
+		list := g.Subs.List(req.GroupID)
+		if len(list) == 0 {
+			return "本群还没有订阅任何仓库"
+		}
+		msg := "本群的订阅："
+		for _, sub := range list {
+			events := "全部"
+			if len(sub.Events) > 0 {
+				events = strings.Join(sub.Events, ",")
+			}
+			msg += fmt.Sprintf("\n%s [%s]", sub.Repo, events)
+		}
+		return msg
+	}
+	if req.Sender == nil || (req.Sender.Role != "owner" && req.Sender.Role != "admin") {
+		return "ERROR: 只有群主和管理员可以管理订阅"
+	}
+	repo := args[0]
+	if !repoPattern.MatchString(repo) {
+		return fmt.Sprintf("ERROR: 仓库格式错误 %s，应为 owner/repo", repo)
+	}
+	switch cmd {
+	case "sub":
+		if err := g.Subs.Subscribe(req.GroupID, repo, args[1:]); err != nil {
+			return fmt.Sprintf("ERROR:%v", err)
+		}
+		if len(args) > 1 {
+			return fmt.Sprintf("已订阅 %s [%s]", repo, strings.Join(args[1:], ","))
+		}
+		return fmt.Sprintf("已订阅 %s", repo)
+	case "unsub":
+		ok, err := g.Subs.Unsubscribe(req.GroupID, repo)
+		if err != nil {
+			return fmt.Sprintf("ERROR:%v", err)
+		}
+		if !ok {
+			return fmt.Sprintf("ERROR: 本群没有订阅 %s", repo)
+		}
+		return fmt.Sprintf("已取消订阅 %s", repo)
+	}
+	return ""
+}
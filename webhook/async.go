@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lanes 按key分道执行任务：同一个key的任务按提交的顺序串行执行，不同key的任务互不阻塞
+type Lanes struct {
+	mu    sync.Mutex
+	lanes map[string][]func() // key -> 还没执行的任务，有记录表示该key正在执行
+	wg    sync.WaitGroup
+}
+
+// NewLanes 初始化
+func NewLanes() *Lanes {
+	return &Lanes{lanes: make(map[string][]func())}
+}
+
+// Go 提交任务，排在同一个key之前提交的任务后面执行
+func (l *Lanes) Go(key string, task func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.wg.Add(1)
+	tasks, running := l.lanes[key]
+	l.lanes[key] = append(tasks, task)
+	if !running {
+		go l.run(key)
+	}
+}
+
+// run 依次执行一个key的任务，执行完后退出
+func (l *Lanes) run(key string) {
+	for {
+		l.mu.Lock()
+		tasks := l.lanes[key]
+		if len(tasks) == 0 {
+			delete(l.lanes, key)
+			l.mu.Unlock()
+			return
+		}
+		task := tasks[0]
+		l.lanes[key] = tasks[1:]
+		l.mu.Unlock()
+		task()
+		l.wg.Done()
+	}
+}
+
+// Wait 等待已提交的任务全部执行完
+func (l *Lanes) Wait() {
+	l.wg.Wait()
+}
+
+// CommitTracker 记录正在处理的event在事件日志中的偏移。
+// event 异步处理时完成顺序和收到的顺序不同，只有更早的event都处理完了才能提交偏移，否则重启后会漏掉
+type CommitTracker struct {
+	mu      sync.Mutex
+	pending []int64 // 正在处理的偏移，从小到大
+	done    int64   // 处理完的最大偏移
+}
+
+// Start 记录开始处理的偏移，偏移为0表示没有写入事件日志，不记录
+func (t *CommitTracker) Start(offset int64) {
+	if offset <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := sort.Search(len(t.pending), func(i int) bool { return t.pending[i] >= offset })
+	t.pending = append(t.pending, 0)
+	copy(t.pending[i+1:], t.pending[i:])
+	t.pending[i] = offset
+}
+
+// Done 记录处理完的偏移，返回可以提交的偏移：更早的event都已处理完的最大偏移
+func (t *CommitTracker) Done(offset int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i := sort.Search(len(t.pending), func(i int) bool { return t.pending[i] >= offset }); i < len(t.pending) && t.pending[i] == offset {
+		t.pending = append(t.pending[:i], t.pending[i+1:]...)
+	}
+	if offset > t.done {
+		t.done = offset
+	}
+	if len(t.pending) > 0 && t.pending[0]-1 < t.done {
+		return t.pending[0] - 1
+	}
+	return t.done
+}
+
+// waitScreenshot 最多等待 wait 时间读取截图结果，没有在时间内完成时返回false
+func waitScreenshot(shot <-chan []byte, wait time.Duration) ([]byte, bool) {
+	select {
+	case pic := <-shot:
+		return pic, true
+	default:
+	}
+	if wait <= 0 {
+		return nil, false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case pic := <-shot:
+		return pic, true
+	case <-timer.C:
+		return nil, false
+	}
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLanes 测试同一个key按顺序执行，不同key互不阻塞
+func TestLanes(t *testing.T) {
+	l := NewLanes()
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, s)
+	}
+	block := make(chan struct{})
+	l.Go("octocat/hello", func() {
+		<-block
+		record("a1")
+	})
+	l.Go("octocat/hello", func() { record("a2") })
+	other := make(chan struct{})
+	l.Go("octocat/world", func() {
+		record("b1")
+		close(other)
+	})
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatalf("octocat/world blocked by octocat/hello")
+	}
+	close(block)
+	l.Wait()
+	if got := strings.Join(order, ","); got != "b1,a1,a2" {
+		t.Errorf("order = %s, want b1,a1,a2", got)
+	}
+}
+
+// TestCommitTracker 测试更早的event处理完之前不提交后面的偏移
+func TestCommitTracker(t *testing.T) {
+	c := &CommitTracker{}
+	c.Start(1)
+	c.Start(2)
+	c.Start(3)
+	if got := c.Done(2); got != 0 {
+		t.Errorf("Done(2) = %d, want 0", got)
+	}
+	if got := c.Done(1); got != 2 {
+		t.Errorf("Done(1) = %d, want 2", got)
+	}
+	c.Start(4)
+	if got := c.Done(4); got != 2 {
+		t.Errorf("Done(4) = %d, want 2", got)
+	}
+	if got := c.Done(3); got != 4 {
+		t.Errorf("Done(3) = %d, want 4", got)
+	}
+}
+
+// TestWaitScreenshot 测试截图超时和按时完成
+func TestWaitScreenshot(t *testing.T) {
+	shot := make(chan []byte, 1)
+	if _, ok := waitScreenshot(shot, 10*time.Millisecond); ok {
+		t.Errorf("waitScreenshot() should time out")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		shot <- []byte("png")
+	}()
+	if pic, ok := waitScreenshot(shot, time.Second); !ok || string(pic) != "png" {
+		t.Errorf("waitScreenshot() = %q %v", pic, ok)
+	}
+}
@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheExt 缓存文件的后缀
+const cacheExt = ".png"
+
+// ScreenshotCache 截图的磁盘缓存，按页面地址和内容的更新时间生成key，内容没变时直接复用之前的截图。
+// 缓存文件总大小超过 maxBytes 时淘汰最久没有使用的文件，使用时间记在文件的修改时间上，重启后仍然有效
+type ScreenshotCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List // 按使用时间从早到晚排列的 *cacheItem
+	inflight map[string]*cacheCall
+	now      func() time.Time
+}
+
+// cacheItem 一个缓存文件
+type cacheItem struct {
+	key  string
+	size int64
+}
+
+// cacheCall 正在生成的截图，同一个key同时只截一次
+type cacheCall struct {
+	done chan struct{}
+	pic  []byte
+	err  error
+}
+
+// CacheKey 按页面地址、内容更新时间等生成缓存的key
+func CacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewScreenshotCache 初始化，加载目录中已有的缓存文件，maxBytes<=0 表示不限制大小
+func NewScreenshotCache(dir string, maxBytes int64) (*ScreenshotCache, error) {
+	c := &ScreenshotCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*cacheCall),
+		now:      time.Now,
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return c, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return c, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != cacheExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		c.add(strings.TrimSuffix(info.Name(), cacheExt), info.Size())
+	}
+	c.evict()
+	return c, nil
+}
+
+// path 缓存文件的路径
+func (c *ScreenshotCache) path(key string) string {
+	return filepath.Join(c.dir, key+cacheExt)
+}
+
+// Get 读取缓存的截图
+func (c *ScreenshotCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	pic, err := os.ReadFile(c.path(key))
+	if err != nil {
+		// 文件被删掉了
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToBack(e)
+	now := c.now()
+	_ = os.Chtimes(c.path(key), now, now)
+	return pic, true
+}
+
+// Put 写入截图，超过大小上限时淘汰最久没有使用的截图
+func (c *ScreenshotCache) Put(key string, pic []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, pic, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		return err
+	}
+	now := c.now()
+	_ = os.Chtimes(c.path(key), now, now)
+	if e, ok := c.items[key]; ok {
+		c.size -= e.Value.(*cacheItem).size
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+	c.add(key, int64(len(pic)))
+	c.evict()
+	return nil
+}
+
+// GetOrRender 有缓存时直接返回，否则调用 render 截图并写入缓存。
+// 同一个key同时只会调用一次 render，其他调用等待结果
+func (c *ScreenshotCache) GetOrRender(key string, render func() ([]byte, error)) ([]byte, error) {
+	if pic, ok := c.Get(key); ok {
+		return pic, nil
+	}
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.pic, call.err
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	call.pic, call.err = render()
+	if call.err == nil && len(call.pic) > 0 {
+		if err := c.Put(key, call.pic); err != nil {
+			log.Errorf("save screenshot cache %s err:%v", key, err)
+		}
+	}
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(call.done)
+	return call.pic, call.err
+}
+
+// Len 缓存的截图数
+func (c *ScreenshotCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Size 缓存文件的总大小
+func (c *ScreenshotCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// add 记录一个缓存文件
+func (c *ScreenshotCache) add(key string, size int64) {
+	c.items[key] = c.order.PushBack(&cacheItem{key: key, size: size})
+	c.size += size
+}
+
+// evict 超过大小上限时淘汰最久没有使用的文件，最近写入的一个总是保留
+func (c *ScreenshotCache) evict() {
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.order.Len() > 1 {
+		e := c.order.Front()
+		if err := os.Remove(c.path(e.Value.(*cacheItem).key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("remove screenshot cache %s err:%v", e.Value.(*cacheItem).key, err)
+		}
+		c.remove(e)
+	}
+}
+
+// remove 删除一条记录
+func (c *ScreenshotCache) remove(e *list.Element) {
+	item := e.Value.(*cacheItem)
+	delete(c.items, item.key)
+	c.size -= item.size
+	c.order.Remove(e)
+}
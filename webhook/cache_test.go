@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestScreenshotCache 测试缓存的读写、按大小淘汰和重启后的加载
+func TestScreenshotCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewScreenshotCache(dir, 10)
+	if err != nil {
+		t.Fatalf("NewScreenshotCache err %v", err)
+	}
+	now := time.Now().Add(-time.Hour)
+	c.now = func() time.Time { return now }
+
+	a := CacheKey("https://github.com/octocat/hello/issues/1", "2022-02-10T10:00:00Z")
+	if a == CacheKey("https://github.com/octocat/hello/issues/1", "2022-02-10T10:05:00Z") {
+		t.Fatalf("CacheKey() should change with updated_at")
+	}
+	b, d := CacheKey("b"), CacheKey("d")
+	_ = c.Put(a, []byte("aaaa"))
+	_ = c.Put(b, []byte("bbbb"))
+	// 读一次 a，淘汰时 b 最久没有使用
+	if pic, ok := c.Get(a); !ok || string(pic) != "aaaa" {
+		t.Fatalf("Get(a) = %q %v", pic, ok)
+	}
+	_ = c.Put(d, []byte("dddd"))
+	if _, ok := c.Get(b); ok {
+		t.Errorf("b should be evicted")
+	}
+	if c.Len() != 2 || c.Size() != 8 {
+		t.Errorf("Len() = %d Size() = %d, want 2 8", c.Len(), c.Size())
+	}
+
+	// 重启后按文件的修改时间恢复使用顺序
+	now = now.Add(time.Minute)
+	c.Get(a)
+	c, err = NewScreenshotCache(dir, 10)
+	if err != nil {
+		t.Fatalf("reload NewScreenshotCache err %v", err)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("reload Len() = %d, want 2", c.Len())
+	}
+	_ = c.Put(b, []byte("bbbb"))
+	if _, ok := c.Get(d); ok {
+		t.Errorf("d should be evicted after reload")
+	}
+	if _, ok := c.Get(a); !ok {
+		t.Errorf("a should be kept after reload")
+	}
+}
+
+// TestScreenshotCacheGetOrRender 测试同一个key只截一次图，失败的结果不缓存
+func TestScreenshotCacheGetOrRender(t *testing.T) {
+	c, err := NewScreenshotCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewScreenshotCache err %v", err)
+	}
+	var calls int32
+	release := make(chan struct{})
+	render := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("png"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if pic, err := c.GetOrRender("k", render); err != nil || !bytes.Equal(pic, []byte("png")) {
+				t.Errorf("GetOrRender() = %q %v", pic, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if _, _ = c.GetOrRender("k", render); calls != 1 {
+		t.Errorf("render called %d times, want 1", calls)
+	}
+
+	fail := errors.New("timeout")
+	if _, err := c.GetOrRender("e", func() ([]byte, error) { return nil, fail }); !errors.Is(err, fail) {
+		t.Errorf("GetOrRender() err = %v, want %v", err, fail)
+	}
+	if _, ok := c.Get("e"); ok {
+		t.Errorf("failed render should not be cached")
+	}
+}
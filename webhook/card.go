@@ -0,0 +1,579 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/hajimehoshi/bitmapfont/v2"
+	"github.com/tidwall/gjson"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// 卡片的尺寸，按 cardScale 放大前
+const (
+	cardWidth    = 400 // 宽度
+	cardPadding  = 12  // 四周的留白
+	cardScale    = 2   // 点阵字体只有 12px，画完后整体放大
+	cardMaxLines = 40  // 正文最多画的行数
+	cardGap      = 6   // 段落之间的间距
+)
+
+// 卡片的配色，和 github 的浅色主题一致
+var (
+	cardBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	cardTextColor  = color.RGBA{0x24, 0x29, 0x2f, 0xff}
+	cardMutedColor = color.RGBA{0x57, 0x60, 0x6a, 0xff}
+	cardBorder     = color.RGBA{0xd0, 0xd7, 0xde, 0xff}
+	cardCodeBg     = color.RGBA{0xf6, 0xf8, 0xfa, 0xff}
+	cardAddBg      = color.RGBA{0xe6, 0xff, 0xec, 0xff}
+	cardDelBg      = color.RGBA{0xff, 0xeb, 0xe9, 0xff}
+	cardOpen       = color.RGBA{0x1a, 0x7f, 0x37, 0xff}
+	cardClosed     = color.RGBA{0xcf, 0x22, 0x2e, 0xff}
+	cardMerged     = color.RGBA{0x82, 0x50, 0xdf, 0xff}
+	cardDraft      = color.RGBA{0x6e, 0x77, 0x81, 0xff}
+)
+
+// cardStyle 卡片中一行的样式
+type cardStyle int
+
+const (
+	cardNormal cardStyle = iota // 普通正文
+	cardBold                    // 标题
+	cardMuted                   // 灰色的说明文字
+	cardCode                    // 代码块
+	cardQuote                   // 引用
+	cardAdd                     // diff 中新增的行
+	cardDel                     // diff 中删除的行
+	cardRule                    // 分割线
+	cardSpace                   // 段落间距
+	cardChips                   // 状态和标签
+)
+
+// cardChip 状态、标签等带底色的小块
+type cardChip struct {
+	text string
+	bg   color.RGBA
+}
+
+// cardLine 卡片中的一行
+type cardLine struct {
+	text   string
+	style  cardStyle
+	indent int
+	chips  []cardChip
+}
+
+// card 排好版的卡片
+type card struct {
+	face  font.Face
+	unit  int // 每个单位的像素数，点阵字体为1，画完后再放大，矢量字体直接按 cardScale 画
+	lines []cardLine
+}
+
+// px 按 unit 换算成像素
+func (c *card) px(n int) int {
+	return n * c.unit
+}
+
+// CardRenderer 不依赖浏览器，把 issue、pull request 和评论画成图片。
+// 默认用内置的点阵字体，支持中日韩文字，也可以指定 ttf、otf、ttc 字体文件画出更清晰的文字
+type CardRenderer struct {
+	mu   sync.Mutex // 矢量字体的 face 不能并发使用
+	face font.Face
+	unit int
+}
+
+// NewCardRenderer 初始化，fontFile 为空时用内置的点阵字体
+func NewCardRenderer(fontFile string) (*CardRenderer, error) {
+	if fontFile == "" {
+		return &CardRenderer{face: bitmapfont.Face, unit: 1}, nil
+	}
+	face, err := loadFace(fontFile, 12*cardScale)
+	if err != nil {
+		return nil, err
+	}
+	return &CardRenderer{face: face, unit: cardScale}, nil
+}
+
+// loadFace 加载字体文件，字体集合取第一个字体
+func loadFace(file string, size float64) (font.Face, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var f *opentype.Font
+	if bytes.HasPrefix(raw, []byte("ttcf")) {
+		collection, err := opentype.ParseCollection(raw)
+		if err != nil {
+			return nil, err
+		}
+		f, err = collection.Font(0)
+		if err != nil {
+			return nil, err
+		}
+	} else if f, err = opentype.Parse(raw); err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Supports 是否能把event画成卡片，和 Render 支持的event一致
+func (r *CardRenderer) Supports(event *Event) bool {
+	switch event.Type {
+	case "issues":
+		return event.Action == "opened" || event.Action == "closed" || event.Action == "reopened"
+	case "pull_request":
+		return event.Action == "opened"
+	case "issue_comment":
+		return event.Action == "created" || event.Action == "edited"
+	case "pull_request_review_comment":
+		return event.Action == "created"
+	}
+	return false
+}
+
+// Render 把event画成 png 图片，不支持的event返回nil
+func (r *CardRenderer) Render(event *Event) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &card{face: r.face, unit: r.unit}
+	p := event.Payload
+	switch {
+	case event.Type == "issues" && (event.Action == "opened" || event.Action == "closed" || event.Action == "reopened"):
+		c.issue(event, p.Get("issue"), false)
+	case event.Type == "pull_request" && event.Action == "opened":
+		c.issue(event, p.Get("pull_request"), true)
+	case event.Type == "issue_comment" && (event.Action == "created" || event.Action == "edited"):
+		c.header(event, p.Get("issue"))
+		c.chips(issueLabels(p.Get("issue"))...)
+		c.rule()
+		verb, at := "commented", p.Get("comment.created_at").String()
+		if event.Action == "edited" {
+			verb, at = "edited a comment", p.Get("comment.updated_at").String()
+		}
+		c.meta(p.Get("comment.user.login").String(), verb, at)
+		c.markdown(p.Get("comment.body").String())
+	case event.Type == "pull_request_review_comment" && event.Action == "created":
+		c.header(event, p.Get("pull_request"))
+		c.rule()
+		file := p.Get("comment.path").String()
+		if line := p.Get("comment.line").Int(); line != 0 {
+			file += ":" + strconv.FormatInt(line, 10)
+		} else if line := p.Get("comment.original_line").Int(); line != 0 {
+			file += ":" + strconv.FormatInt(line, 10)
+		}
+		c.meta(p.Get("comment.user.login").String(), "commented on "+file, p.Get("comment.created_at").String())
+		c.diff(lastLines(8, p.Get("comment.diff_hunk").String()))
+		c.markdown(p.Get("comment.body").String())
+	default:
+		return nil, nil
+	}
+	return c.render()
+}
+
+// issue issue 或 pull request 的卡片：标题、状态、标签、作者和正文
+func (c *card) issue(event *Event, issue gjson.Result, isPR bool) {
+	c.header(event, issue)
+	state := issueState(issue, isPR)
+	c.chips(append([]cardChip{state}, issueLabels(issue)...)...)
+	at := issue.Get("created_at").String()
+	switch event.Action {
+	case "closed":
+		at = issue.Get("closed_at").String()
+	case "reopened":
+		at = issue.Get("updated_at").String()
+	}
+	verb := event.Action
+	if isPR {
+		verb += fmt.Sprintf(" · %s ← %s", issue.Get("base.ref").String(), issue.Get("head.ref").String())
+	} else if n := issue.Get("comments").Int(); n > 0 {
+		verb += fmt.Sprintf(" · %d comments", n)
+	}
+	c.meta(issue.Get("user.login").String(), verb, at)
+	c.rule()
+	c.markdown(issue.Get("body").String())
+}
+
+// header 仓库名、编号和标题
+func (c *card) header(event *Event, issue gjson.Result) {
+	c.text(cardMuted, 0, "", fmt.Sprintf("%s #%d", event.FullName(), issue.Get("number").Int()))
+	c.text(cardBold, 0, "", issue.Get("title").String())
+}
+
+// meta 作者、动作和时间
+func (c *card) meta(user, verb, at string) {
+	s := user + " " + verb
+	if t := cardTime(at); t != "" {
+		s += " · " + t
+	}
+	c.text(cardMuted, 0, "", s)
+}
+
+// cardTime 把 github 的时间格式化成本地时间，解析失败时为空
+func cardTime(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// issueState issue 或 pull request 的状态
+func issueState(issue gjson.Result, isPR bool) cardChip {
+	state := issue.Get("state").String()
+	switch {
+	case isPR && issue.Get("merged").Bool():
+		return cardChip{"Merged", cardMerged}
+	case isPR && issue.Get("draft").Bool():
+		return cardChip{"Draft", cardDraft}
+	case state == "open" || state == "opened":
+		return cardChip{"Open", cardOpen}
+	case isPR && state == "closed":
+		return cardChip{"Closed", cardClosed}
+	case state == "closed" && issue.Get("state_reason").String() == "not_planned":
+		return cardChip{"Closed", cardDraft}
+	case state == "closed":
+		return cardChip{"Closed", cardMerged}
+	}
+	return cardChip{state, cardDraft}
+}
+
+// issueLabels 带颜色的标签
+func issueLabels(issue gjson.Result) []cardChip {
+	var chips []cardChip
+	for _, label := range issue.Get("labels").Array() {
+		chips = append(chips, cardChip{label.Get("name").String(), hexColor(label.Get("color").String())})
+	}
+	return chips
+}
+
+// hexColor 解析 ededed 格式的颜色，失败时为灰色
+func hexColor(s string) color.RGBA {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(s, "#")) != 6 {
+		return cardBorder
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
+
+// chipTextColor 按底色的亮度选择黑色或白色的文字
+func chipTextColor(bg color.RGBA) color.RGBA {
+	if 299*int(bg.R)+587*int(bg.G)+114*int(bg.B) > 150000 {
+		return cardTextColor
+	}
+	return cardBackground
+}
+
+// 解析 markdown 用到的正则
+var (
+	mdComment  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	mdImage    = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)|<img[^>]*>`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdEmphasis = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__|~~(.+?)~~|` + "`([^`]+)`")
+	mdTag      = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	mdHeading  = regexp.MustCompile(`^#{1,6}\s+(.*?)[\s#]*$`)
+	mdRule     = regexp.MustCompile(`^(\*\s*){3,}$|^(-\s*){3,}$|^(_\s*){3,}$`)
+	mdTask     = regexp.MustCompile(`^(\s*)[-*+]\s+\[([ xX])\]\s+(.*)$`)
+	mdBullet   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdOrdered  = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	mdTableSep = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+)
+
+// inline 去掉行内的 markdown 标记，只保留文字
+func inline(s string) string {
+	s = mdImage.ReplaceAllString(s, "[image]")
+	s = mdLink.ReplaceAllString(s, "$1")
+	s = mdEmphasis.ReplaceAllString(s, "$1$2$3$4")
+	return mdTag.ReplaceAllString(s, "")
+}
+
+// markdown 把 markdown 正文转成带简单样式的行，超过 cardMaxLines 行时截断
+func (c *card) markdown(body string) {
+	start := len(c.lines)
+	body = mdComment.ReplaceAllString(strings.ReplaceAll(body, "\r\n", "\n"), "")
+	var fence string
+	for _, raw := range strings.Split(body, "\n") {
+		if len(c.lines)-start >= cardMaxLines {
+			c.truncate(start)
+			return
+		}
+		line := strings.TrimRight(raw, " \t")
+		trim := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trim, fence) {
+				fence = ""
+				continue
+			}
+			c.text(cardCode, 0, "", strings.ReplaceAll(line, "\t", "    "))
+			continue
+		}
+		if strings.HasPrefix(trim, "```") || strings.HasPrefix(trim, "~~~") {
+			fence = trim[:3]
+			continue
+		}
+		switch {
+		case trim == "":
+			c.space()
+		case mdRule.MatchString(trim):
+			c.rule()
+		case mdTableSep.MatchString(trim) && strings.Contains(trim, "|"):
+		case mdHeading.MatchString(trim):
+			c.text(cardBold, 0, "", inline(mdHeading.FindStringSubmatch(trim)[1]))
+		case strings.HasPrefix(trim, ">"):
+			c.text(cardQuote, 0, "", inline(strings.TrimSpace(strings.TrimLeft(trim, "> "))))
+		case mdTask.MatchString(line):
+			m := mdTask.FindStringSubmatch(line)
+			box := "☐ "
+			if m[2] != " " {
+				box = "☑ "
+			}
+			c.text(cardNormal, c.listIndent(m[1]), box, inline(m[3]))
+		case mdBullet.MatchString(line):
+			m := mdBullet.FindStringSubmatch(line)
+			c.text(cardNormal, c.listIndent(m[1]), "• ", inline(m[2]))
+		case mdOrdered.MatchString(line):
+			m := mdOrdered.FindStringSubmatch(line)
+			c.text(cardNormal, c.listIndent(m[1]), m[2]+". ", inline(m[3]))
+		default:
+			if s := inline(trim); s != "" {
+				c.text(cardNormal, 0, "", s)
+			}
+		}
+	}
+	// 没有换行的长段落会折成很多行
+	if len(c.lines)-start > cardMaxLines {
+		c.truncate(start)
+		return
+	}
+	// 去掉末尾的空行
+	for len(c.lines) > start && c.lines[len(c.lines)-1].style == cardSpace {
+		c.lines = c.lines[:len(c.lines)-1]
+	}
+}
+
+// truncate 只保留从 start 开始的 cardMaxLines 行，后面画省略号
+func (c *card) truncate(start int) {
+	c.lines = append(c.lines[:start+cardMaxLines], cardLine{text: "...", style: cardMuted})
+}
+
+// listIndent 列表的缩进，每两个空格缩进一级
+func (c *card) listIndent(spaces string) int {
+	return c.px(len(strings.ReplaceAll(spaces, "\t", "  ")) / 2 * 12)
+}
+
+// diff 带颜色的 diff hunk
+func (c *card) diff(hunk string) {
+	if hunk == "" {
+		return
+	}
+	for _, line := range strings.Split(hunk, "\n") {
+		style := cardCode
+		switch {
+		case strings.HasPrefix(line, "+"):
+			style = cardAdd
+		case strings.HasPrefix(line, "-"):
+			style = cardDel
+		}
+		c.text(style, 0, "", strings.ReplaceAll(line, "\t", "    "))
+	}
+	c.space()
+}
+
+// text 添加一段文字，超过宽度时自动换行，prefix 只出现在第一行，之后的行和 prefix 后的文字对齐
+func (c *card) text(style cardStyle, indent int, prefix, s string) {
+	width := c.px(cardWidth-2*cardPadding) - indent
+	if style == cardCode || style == cardQuote || style == cardAdd || style == cardDel {
+		width -= c.px(8)
+	}
+	hang := font.MeasureString(c.face, prefix).Ceil()
+	for i, line := range c.wrap(s, width-hang) {
+		if i == 0 {
+			c.lines = append(c.lines, cardLine{text: prefix + line, style: style, indent: indent})
+			continue
+		}
+		c.lines = append(c.lines, cardLine{text: line, style: style, indent: indent + hang})
+	}
+}
+
+// wrap 按宽度把文字拆成多行，英文尽量在空格处换行，中日韩文字可以在任意字之间换行
+func (c *card) wrap(s string, width int) []string {
+	var (
+		lines []string
+		line  []rune
+		w     fixed.Int26_6
+		brk   = -1 // 当前行最后一个可以换行的位置，空格处换行时去掉空格
+	)
+	max := fixed.I(width)
+	for _, r := range s {
+		adv, _ := c.face.GlyphAdvance(r)
+		if w+adv > max && len(line) > 0 {
+			switch {
+			case unicode.IsSpace(r) || isWide(r) || brk <= 0:
+				lines = append(lines, string(line))
+				line = nil
+			case unicode.IsSpace(line[brk]):
+				lines = append(lines, string(line[:brk]))
+				line = append([]rune(nil), line[brk+1:]...)
+			default:
+				lines = append(lines, string(line[:brk]))
+				line = append([]rune(nil), line[brk:]...)
+			}
+			w, brk = font.MeasureString(c.face, string(line)), -1
+			if unicode.IsSpace(r) {
+				continue
+			}
+		}
+		if unicode.IsSpace(r) || isWide(r) {
+			brk = len(line)
+		}
+		line = append(line, r)
+		w += adv
+	}
+	return append(lines, string(line))
+}
+
+// isWide 是否为中日韩文字，可以在它前面换行
+func isWide(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// chips 添加一行状态和标签，超过宽度时换行
+func (c *card) chips(chips ...cardChip) {
+	var (
+		row []cardChip
+		w   int
+	)
+	for _, chip := range chips {
+		cw := c.chipWidth(chip)
+		if w+cw > c.px(cardWidth-2*cardPadding) && len(row) > 0 {
+			c.lines = append(c.lines, cardLine{style: cardChips, chips: row})
+			row, w = nil, 0
+		}
+		row = append(row, chip)
+		w += cw + c.px(4)
+	}
+	if len(row) > 0 {
+		c.lines = append(c.lines, cardLine{style: cardChips, chips: row})
+	}
+}
+
+// chipWidth 状态、标签的宽度
+func (c *card) chipWidth(chip cardChip) int {
+	return font.MeasureString(c.face, chip.text).Ceil() + c.px(8)
+}
+
+// rule 分割线
+func (c *card) rule() {
+	c.lines = append(c.lines, cardLine{style: cardRule})
+}
+
+// space 段落间距，连续的空行只算一个
+func (c *card) space() {
+	if n := len(c.lines); n == 0 || c.lines[n-1].style == cardSpace || c.lines[n-1].style == cardRule {
+		return
+	}
+	c.lines = append(c.lines, cardLine{style: cardSpace})
+}
+
+// lineHeight 一行的高度
+func (c *card) lineHeight(line cardLine) int {
+	switch line.style {
+	case cardRule:
+		return c.px(2*cardGap + 1)
+	case cardSpace:
+		return c.px(cardGap)
+	case cardChips:
+		return c.face.Metrics().Height.Ceil() + c.px(6)
+	}
+	return c.face.Metrics().Height.Ceil()
+}
+
+// render 画出卡片并放大成 png
+func (c *card) render() ([]byte, error) {
+	width, padding := c.px(cardWidth), c.px(cardPadding)
+	height := 2 * padding
+	for _, line := range c.lines {
+		height += c.lineHeight(line)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill(img, img.Bounds(), cardBackground)
+	ascent := c.face.Metrics().Ascent.Ceil()
+	y := padding
+	for _, line := range c.lines {
+		h := c.lineHeight(line)
+		x := padding + line.indent
+		switch line.style {
+		case cardRule:
+			fill(img, image.Rect(padding, y+c.px(cardGap), width-padding, y+c.px(cardGap+1)), cardBorder)
+		case cardChips:
+			for _, chip := range line.chips {
+				w := c.chipWidth(chip)
+				fill(img, image.Rect(x, y+c.px(1), x+w, y+h-c.px(1)), chip.bg)
+				c.draw(img, x+c.px(4), y+c.px(3)+ascent, chip.text, chipTextColor(chip.bg), false)
+				x += w + c.px(4)
+			}
+		case cardBold:
+			c.draw(img, x, y+ascent, line.text, cardTextColor, true)
+		case cardMuted:
+			c.draw(img, x, y+ascent, line.text, cardMutedColor, false)
+		case cardQuote:
+			fill(img, image.Rect(x, y, x+c.px(2), y+h), cardBorder)
+			c.draw(img, x+c.px(8), y+ascent, line.text, cardMutedColor, false)
+		case cardCode, cardAdd, cardDel:
+			bg := cardCodeBg
+			if line.style == cardAdd {
+				bg = cardAddBg
+			} else if line.style == cardDel {
+				bg = cardDelBg
+			}
+			fill(img, image.Rect(x, y, width-padding, y+h), bg)
+			c.draw(img, x+c.px(4), y+ascent, line.text, cardTextColor, false)
+		case cardNormal:
+			c.draw(img, x, y+ascent, line.text, cardTextColor, false)
+		}
+		y += h
+	}
+	out := img
+	if scale := cardScale / c.unit; scale > 1 {
+		out = image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+		xdraw.NearestNeighbor.Scale(out, out.Bounds(), img, img.Bounds(), draw.Src, nil)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// draw 在基线 (x, y) 处画文字，bold 时错开一个像素再画一次
+func (c *card) draw(img *image.RGBA, x, y int, s string, col color.RGBA, bold bool) {
+	// 点阵字体的字形会画到起点左边，按字形的边界往右挪
+	if b, _ := font.BoundString(c.face, s); b.Min.X < 0 {
+		x -= b.Min.X.Floor()
+	}
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(col), Face: c.face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+	if bold {
+		d.Dot = fixed.P(x+1, y)
+		d.DrawString(s)
+	}
+}
+
+// fill 用纯色填充矩形
+func fill(img *image.RGBA, r image.Rectangle, col color.RGBA) {
+	draw.Draw(img, r, image.NewUniform(col), image.Point{}, draw.Src)
+}
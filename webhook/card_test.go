@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/bitmapfont/v2"
+)
+
+// TestCardMarkdown 测试 markdown 正文转成的行
+func TestCardMarkdown(t *testing.T) {
+	c := &card{face: bitmapfont.Face, unit: 1}
+	c.markdown("<!-- 请描述问题 -->\r\n## 复现步骤\r\n\r\n\r\n1. 打开 **设置** 页面\n- [x] 看过 [文档](https://example.com)\n  - 子项 `code`\n> 引用\n\n```go\nfunc main() {}\n```\n---\n![截图](https://example.com/a.png)\n\n")
+	want := []cardLine{
+		{text: "复现步骤", style: cardBold},
+		{style: cardSpace},
+		{text: "1. 打开 设置 页面", style: cardNormal},
+		{text: "☑ 看过 文档", style: cardNormal},
+		{text: "• 子项 code", style: cardNormal, indent: 12},
+		{text: "引用", style: cardQuote},
+		{style: cardSpace},
+		{text: "func main() {}", style: cardCode},
+		{style: cardRule},
+		{text: "[image]", style: cardNormal},
+	}
+	if len(c.lines) != len(want) {
+		t.Fatalf("markdown() = %+v, want %d lines", c.lines, len(want))
+	}
+	for i, line := range c.lines {
+		if line.text != want[i].text || line.style != want[i].style || line.indent != want[i].indent {
+			t.Errorf("line %d = %+v, want %+v", i, line, want[i])
+		}
+	}
+
+	c = &card{face: c.face, unit: 1}
+	c.markdown(strings.Repeat("line\n", cardMaxLines+10))
+	if n := len(c.lines); n != cardMaxLines+1 || c.lines[n-1].text != "..." {
+		t.Errorf("markdown() long body got %d lines", n)
+	}
+
+	// 没有换行的长段落
+	c = &card{face: c.face, unit: 1}
+	c.markdown(strings.Repeat("word ", 20000))
+	if n := len(c.lines); n != cardMaxLines+1 || c.lines[n-1].text != "..." {
+		t.Errorf("markdown() long paragraph got %d lines", n)
+	}
+}
+
+// TestCardWrap 测试中英文混排的换行
+func TestCardWrap(t *testing.T) {
+	c := &card{face: bitmapfont.Face, unit: 1}
+	lines := c.wrap("hello world 你好世界", 6*8)
+	want := []string{"hello", "world 你", "好世界"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("wrap() = %q, want %q", lines, want)
+	}
+}
+
+// TestCardRender 测试 issue 和评论画成的图片
+func TestCardRender(t *testing.T) {
+	s := NewServer()
+	r, err := NewCardRenderer("")
+	if err != nil {
+		t.Fatalf("NewCardRenderer err %v", err)
+	}
+	tests := []struct {
+		eventType string
+		body      string
+	}{
+		{"issues", `{"action":"opened","issue":{"number":12,"title":"截图失败 Screenshot fails on long pages","state":"open","body":"## 描述\nselenium 截图超时\n- chrome 98","user":{"login":"octocat"},"created_at":"2022-02-10T10:00:00Z","labels":[{"name":"bug","color":"d73a4a"},{"name":"help wanted","color":"a2eeef"}]}}`},
+		{"pull_request", `{"action":"opened","pull_request":{"number":3,"title":"Fix","state":"open","draft":true,"user":{"login":"octocat"},"base":{"ref":"main"},"head":{"ref":"fix"}}}`},
+		{"issue_comment", `{"action":"created","issue":{"number":12,"title":"截图失败"},"comment":{"body":"已修复","user":{"login":"bob"},"created_at":"2022-02-10T11:00:00Z"}}`},
+		{"pull_request_review_comment", `{"action":"created","pull_request":{"number":3,"title":"Fix"},"comment":{"path":"main.go","line":3,"diff_hunk":"@@ -1,2 +1,3 @@\n package main\n-var a\n+var b","body":"nit","user":{"login":"bob"}}}`},
+	}
+	for _, tt := range tests {
+		event, err := s.parseEvent(tt.eventType, []byte(tt.body[:len(tt.body)-1]+`,"repository":{"name":"hello","full_name":"octocat/hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}}`))
+		if err != nil {
+			t.Fatalf("parseEvent(%s) err %v", tt.eventType, err)
+		}
+		if !r.Supports(event) {
+			t.Errorf("Supports(%s) = false", tt.eventType)
+		}
+		pic, err := r.Render(event)
+		if err != nil {
+			t.Fatalf("Render(%s) err %v", tt.eventType, err)
+		}
+		img, err := png.Decode(bytes.NewReader(pic))
+		if err != nil {
+			t.Fatalf("Render(%s) is not png: %v", tt.eventType, err)
+		}
+		if w := img.Bounds().Dx(); w != cardWidth*cardScale {
+			t.Errorf("Render(%s) width = %d, want %d", tt.eventType, w, cardWidth*cardScale)
+		}
+		if dir := os.Getenv("CARD_TEST_OUTPUT"); dir != "" {
+			_ = os.WriteFile(dir+"/"+tt.eventType+".png", pic, 0o644)
+		}
+	}
+
+	event, _ := s.parseEvent("release", []byte(`{"action":"published","release":{"tag_name":"v1"},"repository":{"full_name":"octocat/hello"}}`))
+	if r.Supports(event) {
+		t.Errorf("Supports(release) = true")
+	}
+	if pic, err := r.Render(event); pic != nil || err != nil {
+		t.Errorf("Render(release) = %d bytes, %v, want nil", len(pic), err)
+	}
+}
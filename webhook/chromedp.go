@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// ErrRendererClosed 截图后端已经关闭
+var ErrRendererClosed = errors.New("screenshot renderer closed")
+
+// ChromedpRenderer 通过 Chrome DevTools Protocol 直接控制 headless chrome 截图，不需要 selenium。
+// 配置了远程调试地址时连接已经运行的 chrome，否则在本机启动一个。
+// 浏览器在第一次截图时启动，之后一直复用，每次截图开一个新的标签页
+type ChromedpRenderer struct {
+	cfg         RendererConfig
+	mu          sync.Mutex
+	browser     context.Context // 浏览器的context，为nil表示还没有启动
+	cancel      func()
+	closed      bool
+	slots       chan struct{} // 限制同时打开的标签页数
+	waitTimeout time.Duration // 等待元素出现的超时时间
+}
+
+// NewChromedpRenderer 初始化
+func NewChromedpRenderer(cfg RendererConfig) *ChromedpRenderer {
+	size := cfg.PoolSize
+	if size <= 0 {
+		size = 1
+	}
+	return &ChromedpRenderer{
+		cfg:         cfg,
+		slots:       make(chan struct{}, size),
+		waitTimeout: time.Minute,
+	}
+}
+
+// start 启动或连接浏览器，已经启动时直接返回
+func (r *ChromedpRenderer) start() (context.Context, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRendererClosed
+	}
+	if r.browser != nil && r.browser.Err() == nil {
+		return r.browser, nil
+	}
+	// 浏览器崩溃或断开后重新启动，先释放之前的 allocator 和浏览器进程
+	if r.cancel != nil {
+		r.cancel()
+		r.browser, r.cancel = nil, nil
+	}
+	var (
+		allocCtx    context.Context
+		allocCancel context.CancelFunc
+	)
+	if r.cfg.Addr != "" {
+		allocCtx, allocCancel = chromedp.NewRemoteAllocator(context.Background(), r.cfg.Addr)
+	} else {
+		opts := append(chromedp.DefaultExecAllocatorOptions[:],
+			chromedp.DisableGPU,
+			chromedp.WindowSize(r.cfg.WindowWidth, r.cfg.WindowHeight),
+		)
+		if r.cfg.ChromeExec != "" {
+			opts = append(opts, chromedp.ExecPath(r.cfg.ChromeExec))
+		}
+		if r.cfg.ProfileDir != "" {
+			opts = append(opts, chromedp.UserDataDir(r.cfg.ProfileDir))
+		}
+		allocCtx, allocCancel = chromedp.NewExecAllocator(context.Background(), opts...)
+	}
+	browser, cancel := chromedp.NewContext(allocCtx)
+	// 没有action的Run会启动浏览器，之后的标签页共用同一份 cookie
+	if err := chromedp.Run(browser, r.setCookies()); err != nil {
+		cancel()
+		allocCancel()
+		return nil, err
+	}
+	r.browser = browser
+	r.cancel = func() {
+		cancel()
+		allocCancel()
+	}
+	return browser, nil
+}
+
+// Render 在新标签页中打开页面，删除不需要的元素，按元素大小调整视口后截取元素
+func (r *ChromedpRenderer) Render(ctx context.Context, req *RenderRequest) ([]byte, error) {
+	select {
+	case r.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-r.slots }()
+	browser, err := r.start()
+	if err != nil {
+		return nil, err
+	}
+	tab, cancel := chromedp.NewContext(browser)
+	defer cancel()
+	// 调用方的ctx结束时关闭标签页
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-tab.Done():
+		}
+	}()
+
+	if err := chromedp.Run(tab,
+		chromedp.EmulateViewport(int64(r.cfg.WindowWidth), int64(r.cfg.WindowHeight), chromedp.EmulateScale(r.cfg.Scale)),
+		r.emulateTheme(),
+		chromedp.Navigate(req.URL),
+	); err != nil {
+		return nil, err
+	}
+	var unavailable bool
+	if err := chromedp.Run(tab, chromedp.Evaluate(unavailableScript, &unavailable)); err == nil && unavailable {
+		return nil, ErrPageUnavailable
+	}
+	waitCtx, waitCancel := context.WithTimeout(tab, r.waitTimeout)
+	_ = chromedp.Run(waitCtx, chromedp.WaitReady(req.waitFor(), queryBy(req.waitFor())))
+	waitCancel()
+	for _, selector := range req.Remove {
+		_ = chromedp.Run(tab, removeNodes(selector))
+	}
+	var box struct{ Width, Height float64 }
+	if err := chromedp.Run(tab, chromedp.Evaluate(rectScript(req.sizeFrom()), &box)); err != nil {
+		return nil, err
+	}
+	selector := req.Selector
+	if req.Fallback != "" && !exists(tab, selector) {
+		// 页面结构变化时退回到 Fallback
+		selector = req.Fallback
+	}
+	var pic []byte
+	err = chromedp.Run(tab,
+		chromedp.EmulateViewport(int64(math.Ceil(box.Width)), int64(math.Ceil(box.Height))+100, chromedp.EmulateScale(r.cfg.Scale)),
+		chromedp.Sleep(req.Delay),
+		chromedp.Screenshot(selector, &pic, queryBy(selector), chromedp.AtLeast(0)),
+	)
+	return pic, err
+}
+
+// setCookies 注入登录 cookie
+func (r *ChromedpRenderer) setCookies() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for _, c := range r.cfg.Cookies {
+			if err := network.SetCookie(c.Name, c.Value).WithURL(cookieURL).WithPath("/").WithSecure(true).Do(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// emulateTheme 模拟系统的 prefers-color-scheme，github 未登录时按它选择配色
+func (r *ChromedpRenderer) emulateTheme() chromedp.Action {
+	if r.cfg.Theme == "" {
+		return chromedp.ActionFunc(func(context.Context) error { return nil })
+	}
+	return emulation.SetEmulatedMedia().WithFeatures([]*emulation.MediaFeature{
+		{Name: "prefers-color-scheme", Value: r.cfg.Theme},
+	})
+}
+
+// Close 关闭浏览器，连接远程 chrome 时只断开连接
+func (r *ChromedpRenderer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.cancel != nil {
+		r.cancel()
+		r.browser, r.cancel = nil, nil
+	}
+	return nil
+}
+
+// queryBy 按 XPath 或 CSS 选择器查找元素
+func queryBy(selector string) chromedp.QueryOption {
+	if isXPath(selector) {
+		return chromedp.BySearch
+	}
+	return chromedp.ByQuery
+}
+
+// exists 元素是否存在，不等待
+func exists(tab context.Context, selector string) bool {
+	var nodes []*cdp.Node
+	err := chromedp.Run(tab, chromedp.Nodes(selector, &nodes, queryBy(selector), chromedp.AtLeast(0)))
+	return err == nil && len(nodes) > 0
+}
+
+// removeNodes 从页面上删除元素
+func removeNodes(selector string) chromedp.Action {
+	return chromedp.Evaluate(findScript(selector)+`.forEach(e => e.remove())`, nil)
+}
+
+// rectScript 获取元素大小的js
+func rectScript(selector string) string {
+	return `(() => { const e = ` + findScript(selector) + `[0]; if (!e) throw new Error("element not found"); const r = e.getBoundingClientRect(); return {Width: r.width, Height: r.height}; })()`
+}
+
+// findScript 按 XPath 或 CSS 选择器查找元素的js，结果为数组
+func findScript(selector string) string {
+	if isXPath(selector) {
+		return `(() => { const r = document.evaluate(` + strconv.Quote(selector) + `, document, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null); const a = []; for (let i = 0; i < r.snapshotLength; i++) a.push(r.snapshotItem(i)); return a; })()`
+	}
+	return `Array.from(document.querySelectorAll(` + strconv.Quote(selector) + `))`
+}
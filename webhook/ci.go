@@ -0,0 +1,209 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// 记录的CI状态
+const (
+	ciSuccess = "success"
+	ciFailure = "failure"
+)
+
+// maxPendingRuns 最多记录多少个还没完成的run的失败job，超过时清空，避免内存无限增长
+const maxPendingRuns = 1000
+
+// CIStatus workflow_run、workflow_job、check_run、check_suite、status 这几类CI event的统一状态
+type CIStatus struct {
+	Name       string        // 工作流名、check 的app名或 status 的 context
+	Job        string        // workflow_job、check_run 的job名，run级别的event为空
+	App        string        // check 的 app.slug，github actions 为 github-actions
+	Branch     string        // 分支
+	SHA        string        // commit
+	Conclusion string        // success、failure、cancelled 等，还没完成时为空
+	Failed     bool          // 是否失败
+	Recovered  bool          // 失败之后第一次成功
+	FailedJobs []string      // run 中失败的job
+	Duration   time.Duration // 耗时
+	URL        string        // run 的地址
+	RunID      int64         // workflow run id 或 check suite id
+}
+
+// NewCIStatus 从event中解析CI状态，不是CI类event时返回nil
+func NewCIStatus(event *Event) *CIStatus {
+	p := event.Payload
+	ci := &CIStatus{}
+	switch event.Type {
+	case "workflow_run":
+		ci.Name = p.Get("workflow_run.name").String()
+		ci.Branch = p.Get("workflow_run.head_branch").String()
+		ci.SHA = p.Get("workflow_run.head_sha").String()
+		ci.Conclusion = p.Get("workflow_run.conclusion").String()
+		ci.URL = p.Get("workflow_run.html_url").String()
+		ci.RunID = p.Get("workflow_run.id").Int()
+		ci.Duration = between(p.Get("workflow_run.run_started_at"), p.Get("workflow_run.updated_at"))
+		if d := p.Get("workflow_run.duration").Int(); d > 0 {
+			ci.Duration = time.Duration(d) * time.Second
+		}
+		for _, job := range p.Get("workflow_run.failed_jobs").Array() {
+			ci.FailedJobs = append(ci.FailedJobs, job.String())
+		}
+	case "workflow_job":
+		ci.Name = p.Get("workflow_job.workflow_name").String()
+		ci.Job = p.Get("workflow_job.name").String()
+		ci.Branch = p.Get("workflow_job.head_branch").String()
+		ci.SHA = p.Get("workflow_job.head_sha").String()
+		ci.Conclusion = p.Get("workflow_job.conclusion").String()
+		ci.URL = p.Get("workflow_job.html_url").String()
+		ci.RunID = p.Get("workflow_job.run_id").Int()
+		ci.Duration = between(p.Get("workflow_job.started_at"), p.Get("workflow_job.completed_at"))
+	case "check_run":
+		ci.Name = p.Get("check_run.app.name").String()
+		ci.App = p.Get("check_run.app.slug").String()
+		ci.Job = p.Get("check_run.name").String()
+		ci.Branch = p.Get("check_run.check_suite.head_branch").String()
+		ci.SHA = p.Get("check_run.head_sha").String()
+		ci.Conclusion = p.Get("check_run.conclusion").String()
+		ci.URL = p.Get("check_run.html_url").String()
+		ci.RunID = p.Get("check_run.check_suite.id").Int()
+		ci.Duration = between(p.Get("check_run.started_at"), p.Get("check_run.completed_at"))
+	case "check_suite":
+		ci.Name = p.Get("check_suite.app.name").String()
+		ci.App = p.Get("check_suite.app.slug").String()
+		ci.Branch = p.Get("check_suite.head_branch").String()
+		ci.SHA = p.Get("check_suite.head_sha").String()
+		ci.Conclusion = p.Get("check_suite.conclusion").String()
+		ci.URL = p.Get("repository.html_url").String() + "/commit/" + ci.SHA + "/checks"
+		ci.RunID = p.Get("check_suite.id").Int()
+		ci.Duration = between(p.Get("check_suite.created_at"), p.Get("check_suite.updated_at"))
+	case "status":
+		ci.Name = p.Get("context").String()
+		ci.Branch = p.Get("branches.0.name").String()
+		ci.SHA = p.Get("sha").String()
+		ci.URL = p.Get("target_url").String()
+		// pending 表示还没有结果
+		if state := p.Get("state").String(); state != "pending" {
+			ci.Conclusion = state
+		}
+	default:
+		return nil
+	}
+	switch ci.Conclusion {
+	case "failure", "timed_out", "startup_failure", "error":
+		ci.Failed = true
+	}
+	return ci
+}
+
+// between 两个时间之间的耗时，精确到秒
+func between(start, end gjson.Result) time.Duration {
+	s, err := time.Parse(time.RFC3339, start.String())
+	if err != nil {
+		return 0
+	}
+	e, err := time.Parse(time.RFC3339, end.String())
+	if err != nil || e.Before(s) {
+		return 0
+	}
+	return e.Sub(s).Round(time.Second)
+}
+
+// key 判断是否恢复时使用的key，同一个仓库、分支、工作流（和job）
+func (ci *CIStatus) key(repo string) string {
+	return repo + "@" + ci.Branch + "#" + ci.Name + "/" + ci.Job
+}
+
+// CITracker 记录每个工作流最近一次的结果，用于判断失败后的恢复，
+// 同时收集 run 中失败的job，在 run 完成时一起推送。file 不为空时持久化到文件
+type CITracker struct {
+	mu     sync.Mutex
+	file   string
+	states map[string]string  // key -> success、failure
+	jobs   map[int64][]string // run id -> 失败的job
+}
+
+// NewCITracker 初始化，file 不为空时从文件加载之前的状态
+func NewCITracker(file string) (*CITracker, error) {
+	t := &CITracker{
+		file:   file,
+		states: make(map[string]string),
+		jobs:   make(map[int64][]string),
+	}
+	if file == "" {
+		return t, nil
+	}
+	raw, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	return t, json.Unmarshal(raw, &t.states)
+}
+
+// Update 记录一次完成的CI结果，填充 ci.Recovered 和 ci.FailedJobs
+func (t *CITracker) Update(repo string, ci *CIStatus) error {
+	if ci.Conclusion == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ci.RunID != 0 {
+		if ci.Job != "" {
+			if ci.Failed {
+				if len(t.jobs) >= maxPendingRuns {
+					t.jobs = make(map[int64][]string)
+				}
+				t.jobs[ci.RunID] = append(t.jobs[ci.RunID], ci.Job)
+			}
+		} else {
+			if len(ci.FailedJobs) == 0 {
+				ci.FailedJobs = t.jobs[ci.RunID]
+			}
+			delete(t.jobs, ci.RunID)
+		}
+	}
+	key := ci.key(repo)
+	prev := t.states[key]
+	switch {
+	case ci.Failed:
+		t.states[key] = ciFailure
+	case ci.Conclusion == ciSuccess:
+		ci.Recovered = prev == ciFailure
+		t.states[key] = ciSuccess
+	default:
+		// cancelled、skipped 等不改变状态
+		return nil
+	}
+	if prev == t.states[key] {
+		return nil
+	}
+	return t.save()
+}
+
+// save 持久化到文件，先写临时文件再重命名
+func (t *CITracker) save() error {
+	if t.file == "" {
+		return nil
+	}
+	raw, err := json.Marshal(t.states)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.file), 0o755); err != nil {
+		return err
+	}
+	tmp := t.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
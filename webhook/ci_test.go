@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ciEvent 解析CI event
+func ciEvent(t *testing.T, eventType, body string) (*Event, *CIStatus) {
+	t.Helper()
+	event, err := NewServer().parseEvent(eventType, []byte(body))
+	if err != nil {
+		t.Fatalf("parseEvent(%s) err %v", eventType, err)
+	}
+	return event, NewCIStatus(event)
+}
+
+const ciRepo = `"repository":{"name":"hello","full_name":"octocat/hello","html_url":"https://github.com/octocat/hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}`
+
+// TestCITracker 测试失败job的收集、失败后恢复的判断和状态持久化
+func TestCITracker(t *testing.T) {
+	tpl, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "ci.json")
+	tracker, err := NewCITracker(file)
+	if err != nil {
+		t.Fatalf("NewCITracker err %v", err)
+	}
+
+	_, job := ciEvent(t, "workflow_job", `{"action":"completed","workflow_job":{"run_id":7,"name":"lint","workflow_name":"build","head_branch":"main","head_sha":"abc1234def","conclusion":"failure","started_at":"2022-02-10T10:00:00Z","completed_at":"2022-02-10T10:01:00Z"},`+ciRepo+`}`)
+	if err := tracker.Update("octocat/hello", job); err != nil {
+		t.Fatalf("Update err %v", err)
+	}
+	failedRun := `{"action":"completed","workflow_run":{"id":7,"name":"build","head_branch":"main","head_sha":"abc1234def","conclusion":"failure","html_url":"https://github.com/octocat/hello/actions/runs/7","run_started_at":"2022-02-10T10:00:00Z","updated_at":"2022-02-10T10:03:12Z"},` + ciRepo + `}`
+	event, ci := ciEvent(t, "workflow_run", failedRun)
+	if err := tracker.Update(event.FullName(), ci); err != nil {
+		t.Fatalf("Update err %v", err)
+	}
+	if !ci.Failed || ci.Recovered || ci.Duration != 192*time.Second {
+		t.Errorf("failed run = %+v", ci)
+	}
+	got, err := tpl.Render(&TemplateData{Event: event, Payload: event.Payload, CI: ci})
+	if err != nil {
+		t.Fatalf("Render err %v", err)
+	}
+	want := "CI failed on main @abc1234 (build / lint) — 3m12s\nrepo: octocat/hello\njump: https://github.com/octocat/hello/actions/runs/7"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	// 重启后第一次成功算恢复，之后的成功不算
+	tracker, err = NewCITracker(file)
+	if err != nil {
+		t.Fatalf("reload NewCITracker err %v", err)
+	}
+	successRun := `{"action":"completed","workflow_run":{"id":8,"name":"build","head_branch":"main","head_sha":"bcd2345","conclusion":"success"},` + ciRepo + `}`
+	for i, wantRecovered := range []bool{true, false} {
+		event, ci = ciEvent(t, "workflow_run", successRun)
+		if err := tracker.Update(event.FullName(), ci); err != nil {
+			t.Fatalf("Update err %v", err)
+		}
+		if ci.Recovered != wantRecovered || len(ci.FailedJobs) != 0 {
+			t.Errorf("success run %d Recovered = %v FailedJobs = %v, want %v", i, ci.Recovered, ci.FailedJobs, wantRecovered)
+		}
+	}
+	got, _ = tpl.Render(&TemplateData{Event: event, Payload: event.Payload, CI: ci})
+	if want := "CI passed on main @bcd2345 (build)\nrepo: octocat/hello\njump: "; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	// pending 的 status 还没有结果
+	event, ci = ciEvent(t, "status", `{"state":"pending","context":"ci/circleci","sha":"cde3456","branches":[{"name":"dev"}],`+ciRepo+`}`)
+	if event.Action != "pending" || ci.Conclusion != "" {
+		t.Errorf("pending status = %s %+v", event.Action, ci)
+	}
+	_, ci = ciEvent(t, "status", `{"state":"error","context":"ci/circleci","sha":"cde3456","branches":[{"name":"dev"}],"target_url":"https://circleci.com/1",`+ciRepo+`}`)
+	if !ci.Failed || ci.Name != "ci/circleci" || ci.Branch != "dev" {
+		t.Errorf("error status = %+v", ci)
+	}
+
+	_, ci = ciEvent(t, "check_suite", `{"action":"completed","check_suite":{"id":9,"head_branch":"main","head_sha":"abc","conclusion":"success","app":{"slug":"github-actions","name":"GitHub Actions"}},`+ciRepo+`}`)
+	if ci.App != "github-actions" || ci.URL != "https://github.com/octocat/hello/commit/abc/checks" {
+		t.Errorf("check_suite = %+v", ci)
+	}
+}
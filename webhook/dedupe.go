@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Deduper 记录已经处理过的 X-GitHub-Delivery，避免github重试、手动重新投递时重复推送。
+// 最多保留 size 条记录，超过 ttl 的记录会过期，file 不为空时持久化到文件
+type Deduper struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	file  string
+	seen  map[string]*list.Element
+	order *list.List // 按记录时间从早到晚排列的 *delivery
+	now   func() time.Time
+}
+
+// delivery 一条投递记录
+type delivery struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// NewDeduper 初始化去重器，file 不为空时从文件加载之前的记录
+func NewDeduper(size int, ttl time.Duration, file string) (*Deduper, error) {
+	d := &Deduper{
+		size:  size,
+		ttl:   ttl,
+		file:  file,
+		seen:  make(map[string]*list.Element),
+		order: list.New(),
+		now:   time.Now,
+	}
+	if file == "" {
+		return d, nil
+	}
+	raw, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return d, err
+	}
+	var records []delivery
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return d, err
+	}
+	for i := range records {
+		d.add(records[i])
+	}
+	d.expire()
+	return d, nil
+}
+
+// Seen 判断投递是否已经处理过，没有处理过时记录下来并返回false
+func (d *Deduper) Seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	d.add(delivery{ID: id, Time: d.now()})
+	if err := d.save(); err != nil {
+		log.Errorf("save webhook deliveries to %s err:%v", d.file, err)
+	}
+	return false
+}
+
+// Len 当前记录的投递数
+func (d *Deduper) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// add 追加记录，超过上限时淘汰最早的记录
+func (d *Deduper) add(r delivery) {
+	if _, ok := d.seen[r.ID]; ok {
+		return
+	}
+	d.seen[r.ID] = d.order.PushBack(&r)
+	for d.size > 0 && d.order.Len() > d.size {
+		d.remove(d.order.Front())
+	}
+}
+
+// expire 淘汰过期的记录
+func (d *Deduper) expire() {
+	if d.ttl <= 0 {
+		return
+	}
+	deadline := d.now().Add(-d.ttl)
+	for e := d.order.Front(); e != nil && e.Value.(*delivery).Time.Before(deadline); e = d.order.Front() {
+		d.remove(e)
+	}
+}
+
+// remove 删除一条记录
+func (d *Deduper) remove(e *list.Element) {
+	delete(d.seen, e.Value.(*delivery).ID)
+	d.order.Remove(e)
+}
+
+// save 持久化到文件
+func (d *Deduper) save() error {
+	if d.file == "" {
+		return nil
+	}
+	records := make([]delivery, 0, d.order.Len())
+	for e := d.order.Front(); e != nil; e = e.Next() {
+		records = append(records, *e.Value.(*delivery))
+	}
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.file), 0o755); err != nil {
+		return err
+	}
+	tmp := d.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.file)
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDeduper 测试去重的上限、过期和持久化
+func TestDeduper(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "deliveries.json")
+	d, err := NewDeduper(2, time.Hour, file)
+	if err != nil {
+		t.Fatalf("NewDeduper err %v", err)
+	}
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	if d.Seen("a") || d.Seen("b") {
+		t.Fatalf("first delivery should not be seen")
+	}
+	if !d.Seen("a") {
+		t.Errorf("a should be seen")
+	}
+	// 超过上限，淘汰最早的 a
+	if d.Seen("c") {
+		t.Fatalf("c should not be seen")
+	}
+	if d.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", d.Len())
+	}
+	if d.Seen("a") {
+		t.Errorf("a should be evicted")
+	}
+
+	reloaded, err := NewDeduper(2, time.Hour, file)
+	if err != nil {
+		t.Fatalf("reload err %v", err)
+	}
+	reloaded.now = func() time.Time { return now }
+	if !reloaded.Seen("c") || !reloaded.Seen("a") {
+		t.Errorf("persisted deliveries should be seen")
+	}
+
+	// 过期
+	now = now.Add(2 * time.Hour)
+	if reloaded.Seen("c") {
+		t.Errorf("c should be expired")
+	}
+}
+
+// TestServeHTTPDuplicateDelivery 重复投递返回200但不放入 Events
+func TestServeHTTPDuplicateDelivery(t *testing.T) {
+	s := NewServer()
+	s.Deduper, _ = NewDeduper(10, time.Hour, "")
+	body := `{"action":"created","sender":{"login":"octocat"},"repository":{"name":"hello","owner":{"login":"octocat"}}}`
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, s.Path, strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "star")
+		req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP() code = %d, want 200", w.Code)
+		}
+	}
+	event := <-s.Events
+	if event.DeliveryID != "72d3162e-cc78-11e3-81ab-4c9367dc0958" {
+		t.Errorf("DeliveryID = %q", event.DeliveryID)
+	}
+	select {
+	case event := <-s.Events:
+		t.Errorf("duplicate delivery emitted %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,384 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt = ".jsonl"     // 日志分段文件后缀
+	offsetFile = "offset"     // 已处理到的偏移
+	timeLayout = time.RFC3339 // replay 命令的时间格式
+)
+
+// recordHeaders 写入事件日志的请求头，重新处理时只需要event类型、投递id和签名。
+// X-Gitlab-Token、Authorization、Cookie 等凭据不会写入磁盘
+var recordHeaders = []string{
+	"Content-Type",
+	"User-Agent",
+	"X-GitHub-Event",
+	"X-GitHub-Delivery",
+	"X-GitHub-Hook-ID",
+	"X-Hub-Signature",
+	"X-Hub-Signature-256",
+	"X-Gitlab-Event",
+	"X-Gitlab-Event-UUID",
+	"X-Gitlab-Webhook-UUID",
+	"X-Gitea-Event",
+	"X-Gitea-Delivery",
+	"X-Gitea-Signature",
+	"X-Forgejo-Event",
+	"X-Forgejo-Delivery",
+	"X-Forgejo-Signature",
+}
+
+// recordHeader 过滤出需要写入事件日志的请求头
+func recordHeader(header http.Header) http.Header {
+	h := http.Header{}
+	for _, key := range recordHeaders {
+		if v := header.Values(key); len(v) > 0 {
+			h[http.CanonicalHeaderKey(key)] = v
+		}
+	}
+	return h
+}
+
+// Record 事件日志中的一条投递
+type Record struct {
+	Offset int64       `json:"offset"` // 从1开始递增
+	Time   time.Time   `json:"time"`   // 收到的时间
+	Path   string      `json:"path"`   // 请求路径
+	Header http.Header `json:"header"` // 请求头
+	Body   []byte      `json:"body"`   // 原始请求体
+}
+
+// DeliveryID 记录的 X-GitHub-Delivery，gitlab 的投递为 X-Gitlab-Event-UUID，gitea 为 X-Gitea-Delivery
+func (r *Record) DeliveryID() string {
+	if r.Header.Get("X-Gitlab-Event") != "" {
+		return gitlabDeliveryID(r.Header)
+	}
+	if giteaHeader(r.Header, "Event") != "" {
+		return giteaHeader(r.Header, "Delivery")
+	}
+	return r.Header.Get("X-GitHub-Delivery")
+}
+
+// EventLog 追加写的事件日志，按分段保存为jsonl文件。
+// 每个分段文件以其第一条记录的偏移命名，超过 segmentSize 条时切换到新的分段
+type EventLog struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int
+	maxSegments int
+	next        int64    // 下一条记录的偏移
+	segments    []int64  // 所有分段的起始偏移，从小到大
+	count       int      // 当前分段的记录数
+	file        *os.File // 当前分段
+	committed   int64    // 已处理到的偏移
+	readOnly    bool     // 只读打开，不能追加和提交
+}
+
+// ErrEventLogReadOnly 只读打开的事件日志不能追加和提交
+var ErrEventLogReadOnly = errors.New("event log opened read-only")
+
+// OpenEventLog 打开或创建事件日志目录。
+// segmentSize 每个分段的记录数，maxSegments 最多保留的分段数，只会删除已经处理完的分段，<=0 表示不删除
+func OpenEventLog(dir string, segmentSize, maxSegments int) (*EventLog, error) {
+	if segmentSize <= 0 {
+		segmentSize = 1000
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &EventLog{
+		dir:         dir,
+		segmentSize: segmentSize,
+		maxSegments: maxSegments,
+		next:        1,
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	if len(l.segments) > 0 {
+		last := l.segments[len(l.segments)-1]
+		l.next = last
+		if err := truncatePartial(l.segmentPath(last)); err != nil {
+			return nil, err
+		}
+		err := l.readSegment(last, func(r *Record) error {
+			l.next = r.Offset + 1
+			l.count++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		l.file, err = os.OpenFile(l.segmentPath(last), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// OpenEventLogReadOnly 只读打开事件日志，用于 replay 命令。
+// 不会截断写了一半的记录，也不会追加或提交偏移，可以和正在运行的服务同时使用
+func OpenEventLogReadOnly(dir string) (*EventLog, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	l := &EventLog{dir: dir, next: 1, readOnly: true}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load 读取分段列表和已处理到的偏移
+func (l *EventLog) load() error {
+	files, err := filepath.Glob(filepath.Join(l.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		start, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(file), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, start)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	if raw, err := os.ReadFile(filepath.Join(l.dir, offsetFile)); err == nil {
+		l.committed, _ = strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	}
+	return nil
+}
+
+// segmentPath 分段文件路径
+func (l *EventLog) segmentPath(start int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", start, segmentExt))
+}
+
+// Append 追加一条投递，返回它的偏移，只保存 recordHeaders 中的请求头
+func (l *EventLog) Append(path string, header http.Header, body []byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readOnly {
+		return 0, ErrEventLogReadOnly
+	}
+	if l.file == nil || l.count >= l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	r := Record{
+		Offset: l.next,
+		Time:   time.Now(),
+		Path:   path,
+		Header: recordHeader(header),
+		Body:   body,
+	}
+	raw, err := json.Marshal(&r)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := l.file.Write(append(raw, '\n')); err != nil {
+		return 0, err
+	}
+	if err := l.file.Sync(); err != nil {
+		return 0, err
+	}
+	l.next++
+	l.count++
+	return r.Offset, nil
+}
+
+// rotate 切换到新的分段，并删除多余的已处理分段
+func (l *EventLog) rotate() error {
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(l.segmentPath(l.next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.count = 0
+	l.segments = append(l.segments, l.next)
+	// 删除最早的分段，要求分段内的记录都已经处理完
+	for l.maxSegments > 0 && len(l.segments) > l.maxSegments && l.segments[1] <= l.committed+1 {
+		if err := os.Remove(l.segmentPath(l.segments[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// Commit 记录已经处理到的偏移，只会往前推进
+func (l *EventLog) Commit(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readOnly {
+		return ErrEventLogReadOnly
+	}
+	if offset <= l.committed {
+		return nil
+	}
+	l.committed = offset
+	tmp := filepath.Join(l.dir, offsetFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.dir, offsetFile))
+}
+
+// Committed 已经处理到的偏移
+func (l *EventLog) Committed() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.committed
+}
+
+// Scan 依次读取偏移 >= from 的记录，fn 返回错误时停止
+func (l *EventLog) Scan(from int64, fn func(r *Record) error) error {
+	l.mu.Lock()
+	segments := append([]int64(nil), l.segments...)
+	l.mu.Unlock()
+	for i, start := range segments {
+		// 下一个分段的起始偏移不大于 from，说明整个分段都在 from 之前
+		if i+1 < len(segments) && segments[i+1] <= from {
+			continue
+		}
+		err := l.readSegment(start, func(r *Record) error {
+			if r.Offset < from {
+				return nil
+			}
+			return fn(r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment 读取一个分段的所有记录，最后一行写了一半的记录会被忽略
+func (l *EventLog) readSegment(start int64, fn func(r *Record) error) error {
+	file, err := os.Open(l.segmentPath(start))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			errmsg := fmt.Sprintf("bad record in segment %d: %v", start, err)
+			return errors.New(errmsg)
+		}
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+}
+
+// truncatePartial 进程在写入时退出会留下写了一半的最后一行，截断掉，避免和之后追加的记录连在一起
+func truncatePartial(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if start+int64(i)+1 == info.Size() {
+				return nil
+			}
+			return file.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	return file.Truncate(0)
+}
+
+// Close 关闭当前分段
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// ReplayFilter replay 的筛选条件，都为空时表示全部
+type ReplayFilter struct {
+	DeliveryID string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Match 判断记录是否满足筛选条件
+func (f *ReplayFilter) Match(r *Record) bool {
+	if f.DeliveryID != "" && r.DeliveryID() != f.DeliveryID {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ParseReplayTime 解析 replay 命令的时间参数，支持 RFC3339 和 "2006-01-02 15:04:05"
+func ParseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(timeLayout, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
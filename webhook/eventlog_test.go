@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEventLog 测试事件日志的追加、分段、偏移和重启恢复
+func TestEventLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenEventLog(dir, 2, 2)
+	if err != nil {
+		t.Fatalf("OpenEventLog err %v", err)
+	}
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "star")
+	for i := 1; i <= 3; i++ {
+		header.Set("X-GitHub-Delivery", string(rune('a'+i-1)))
+		offset, err := l.Append("/postreceive", header, []byte(`{"action":"created"}`))
+		if err != nil {
+			t.Fatalf("Append err %v", err)
+		}
+		if offset != int64(i) {
+			t.Errorf("Append() offset = %d, want %d", offset, i)
+		}
+	}
+	if err := l.Commit(2); err != nil {
+		t.Fatalf("Commit err %v", err)
+	}
+	_ = l.Close()
+
+	// 模拟写了一半退出
+	f, err := os.OpenFile(filepath.Join(dir, "00000000000000000003.jsonl"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open segment err %v", err)
+	}
+	_, _ = f.WriteString(`{"offset":4,"ti`)
+	_ = f.Close()
+
+	l, err = OpenEventLog(dir, 2, 2)
+	if err != nil {
+		t.Fatalf("reopen err %v", err)
+	}
+	defer l.Close()
+	if l.Committed() != 2 {
+		t.Errorf("Committed() = %d, want 2", l.Committed())
+	}
+	var ids []string
+	err = l.Scan(l.Committed()+1, func(r *Record) error {
+		ids = append(ids, r.DeliveryID())
+		return nil
+	})
+	if err != nil || len(ids) != 1 || ids[0] != "c" {
+		t.Fatalf("Scan() = %v, %v, want [c]", ids, err)
+	}
+	offset, err := l.Append("/postreceive", header, []byte(`{}`))
+	if err != nil || offset != 4 {
+		t.Fatalf("Append() after reopen = %d, %v, want 4", offset, err)
+	}
+
+	// 第三个分段创建时删除已经处理完的第一个分段
+	if err := l.Commit(4); err != nil {
+		t.Fatalf("Commit err %v", err)
+	}
+	if _, err := l.Append("/postreceive", header, []byte(`{}`)); err != nil {
+		t.Fatalf("Append err %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000001.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("first segment should be removed, err %v", err)
+	}
+	var count int
+	_ = l.Scan(0, func(r *Record) error {
+		count++
+		return nil
+	})
+	if count != 3 {
+		t.Errorf("Scan(0) count = %d, want 3", count)
+	}
+}
+
+// TestEventLogHeader 测试事件日志不保存凭据相关的请求头
+func TestEventLogHeader(t *testing.T) {
+	l, err := OpenEventLog(t.TempDir(), 10, 0)
+	if err != nil {
+		t.Fatalf("OpenEventLog err %v", err)
+	}
+	defer l.Close()
+	header := http.Header{}
+	header.Set("X-Gitlab-Event", "Push Hook")
+	header.Set("X-Gitlab-Event-UUID", "uuid")
+	header.Set("X-Gitlab-Token", "secret-token")
+	header.Set("Authorization", "Bearer secret")
+	header.Set("Cookie", "user_session=secret")
+	if _, err := l.Append("/gitlab", header, []byte(`{}`)); err != nil {
+		t.Fatalf("Append err %v", err)
+	}
+	err = l.Scan(0, func(r *Record) error {
+		if r.DeliveryID() != "uuid" || r.Header.Get("X-Gitlab-Event") != "Push Hook" {
+			t.Errorf("record header = %v", r.Header)
+		}
+		for _, key := range []string{"X-Gitlab-Token", "Authorization", "Cookie"} {
+			if r.Header.Get(key) != "" {
+				t.Errorf("record should not keep %s", key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan err %v", err)
+	}
+}
+
+// TestEventLogReadOnly 测试只读打开时不截断、不追加、不提交
+func TestEventLogReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenEventLog(dir, 10, 0)
+	if err != nil {
+		t.Fatalf("OpenEventLog err %v", err)
+	}
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "star")
+	header.Set("X-GitHub-Delivery", "a")
+	if _, err := l.Append("/postreceive", header, []byte(`{}`)); err != nil {
+		t.Fatalf("Append err %v", err)
+	}
+	// 模拟服务正在写入
+	segment := l.segmentPath(1)
+	f, _ := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0o644)
+	_, _ = f.WriteString(`{"offset":2,"ti`)
+	_ = f.Close()
+	before, _ := os.ReadFile(segment)
+
+	r, err := OpenEventLogReadOnly(dir)
+	if err != nil {
+		t.Fatalf("OpenEventLogReadOnly err %v", err)
+	}
+	var ids []string
+	if err := r.Scan(0, func(r *Record) error {
+		ids = append(ids, r.DeliveryID())
+		return nil
+	}); err != nil || len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("Scan() = %v, %v, want [a]", ids, err)
+	}
+	if _, err := r.Append("/postreceive", header, []byte(`{}`)); !errors.Is(err, ErrEventLogReadOnly) {
+		t.Errorf("Append() err = %v, want ErrEventLogReadOnly", err)
+	}
+	if err := r.Commit(1); !errors.Is(err, ErrEventLogReadOnly) {
+		t.Errorf("Commit() err = %v, want ErrEventLogReadOnly", err)
+	}
+	if after, _ := os.ReadFile(segment); string(after) != string(before) {
+		t.Errorf("read-only open should not truncate the live segment")
+	}
+	_ = l.Close()
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ProviderGitea gitea、forgejo 的事件
+const ProviderGitea = "gitea"
+
+// DefaultGiteaPath 配置了 gitea secret 但没有配置路径时接收gitea投递的路径
+const DefaultGiteaPath = "/gitea"
+
+// ErrGiteaSecret 没有配置 gitea 的secret
+var ErrGiteaSecret = errors.New("gitea webhook secret not configured")
+
+// ErrMissingGiteaSignature 请求头里没有 X-Gitea-Signature
+var ErrMissingGiteaSignature = errors.New("missing X-Gitea-Signature required for HMAC verification")
+
+// giteaEvents gitea 的 X-Gitea-Event 对应的 github event 类型
+var giteaEvents = map[string]string{
+	"push":                  "push",
+	"create":                "create",
+	"fork":                  "fork",
+	"issues":                "issues",
+	"issue_comment":         "issue_comment",
+	"pull_request":          "pull_request",
+	"pull_request_approved": "pull_request_review",
+	"pull_request_rejected": "pull_request_review",
+	"pull_request_comment":  "issue_comment", // payload 和 issue_comment 一样，is_pull 为 true
+	"release":               "release",
+}
+
+// giteaHeader 读取 gitea 的请求头，forgejo 的请求头是 X-Forgejo-*
+func giteaHeader(header http.Header, name string) string {
+	if v := header.Get("X-Gitea-" + name); v != "" {
+		return v
+	}
+	return header.Get("X-Forgejo-" + name)
+}
+
+// serveGitea 处理 gitea、forgejo 的投递
+func (s *Server) serveGitea(w http.ResponseWriter, req *http.Request) {
+	giteaEvent := giteaHeader(req.Header, "Event")
+	if giteaEvent == "" {
+		writeError(w, http.StatusBadRequest, "400 Bad Request - Missing X-Gitea-Event Header")
+		return
+	}
+	if _, ok := giteaEvents[giteaEvent]; !ok {
+		writeError(w, http.StatusBadRequest, "400 Bad Request - Unknown Event Type "+giteaEvent)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.verifyGiteaSignature(body, giteaHeader(req.Header, "Signature")); err != nil {
+		writeError(w, http.StatusForbidden, "403 Forbidden - "+err.Error())
+		return
+	}
+	s.accept(w, req, giteaEvents[giteaEvent], giteaHeader(req.Header, "Delivery"), body, func() (*Event, error) {
+		return s.parseGiteaEvent(giteaEvent, body)
+	})
+}
+
+// verifyGiteaSignature 校验 X-Gitea-Signature，是不带前缀的hex格式 HMAC-SHA256，没有配置secret时拒绝
+func (s *Server) verifyGiteaSignature(body []byte, sig string) error {
+	if len(s.GiteaSecrets) == 0 {
+		return ErrGiteaSecret
+	}
+	if sig == "" {
+		return ErrMissingGiteaSignature
+	}
+	if checkSignature(sha256.New, "", s.GiteaSecrets, body, sig) {
+		return nil
+	}
+	return ErrSignatureMismatch
+}
+
+// parseGiteaEvent gitea 的 payload 和 github 基本一致，补齐字段名、action 的差异后按 github 的 event 解析
+func (s *Server) parseGiteaEvent(giteaEvent string, body []byte) (*Event, error) {
+	var payload obj
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	eventType := giteaEvents[giteaEvent]
+
+	// 旧版本 gitea 的用户只有 username
+	giteaLogin(payload, "sender")
+	giteaLogin(payload, "repository", "owner")
+	giteaLogin(payload, "release", "author")
+	giteaLogin(payload, "issue", "user")
+	giteaLogin(payload, "comment", "user")
+	giteaLogin(payload, "pull_request", "user")
+	giteaLogin(payload, "pull_request", "merged_by")
+	giteaLogin(payload, "pull_request", "head", "repo", "owner")
+	giteaLogin(payload, "pull_request", "base", "repo", "owner")
+
+	switch eventType {
+	case "push":
+		payload["compare"] = payload["compare_url"]
+		if after, _ := payload["after"].(string); strings.Trim(after, "0") == "" {
+			payload["deleted"] = true
+		}
+	case "pull_request":
+		if payload["action"] == "synchronized" {
+			payload["action"] = "synchronize"
+		}
+	case "pull_request_review":
+		payload["action"] = "submitted"
+		review, _ := payload["review"].(map[string]interface{})
+		if review == nil {
+			review = map[string]interface{}{}
+		}
+		switch giteaEvent {
+		case "pull_request_approved":
+			review["state"] = "approved"
+		case "pull_request_rejected":
+			review["state"] = "changes_requested"
+		default:
+			review["state"] = "commented"
+		}
+		review["body"] = review["content"]
+		payload["review"] = review
+	case "release":
+		if payload["action"] == "updated" {
+			payload["action"] = "edited"
+		}
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	event, err := s.parseEvent(eventType, raw)
+	if err != nil {
+		return nil, err
+	}
+	event.Provider = ProviderGitea
+	return event, nil
+}
+
+// giteaLogin 对象中只有 username 时补上 login
+func giteaLogin(payload obj, path ...string) {
+	o := payload
+	for _, key := range path {
+		next, ok := o[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		o = next
+	}
+	if login, _ := o["login"].(string); login == "" {
+		if username, ok := o["username"].(string); ok {
+			o["login"] = username
+		}
+	}
+}
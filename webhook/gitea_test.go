@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// giteaSign 计算 X-Gitea-Signature
+func giteaSign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestServeGitea 使用 testdata/gitea 下的 payload 测试 gitea 投递的签名校验、解析和模板渲染
+func TestServeGitea(t *testing.T) {
+	tpl, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	tests := []struct {
+		fixture    string
+		wantType   string
+		wantAction string
+		wantOwner  string
+		wantUser   string
+		want       string
+	}{
+		{
+			fixture:   "push",
+			wantType:  "push",
+			wantOwner: "gitea",
+			wantUser:  "gitea",
+			want:      "gitea pushed 1 commit to gitea/webhooks:main\n[bffeb74] Webhooks Yay! - Gitea\ncompare: https://gitea.example.com/gitea/webhooks/compare/28e1879d029cb852e4844d9c718537df08844e03...bffeb74224043ba2feb48d137756c8a9331c449a",
+		},
+		{
+			fixture:    "issues",
+			wantType:   "issues",
+			wantAction: "opened",
+			wantOwner:  "gitea",
+			wantUser:   "alice",
+			want:       "alice opened issue gitea/webhooks #12 \njump: https://gitea.example.com/gitea/webhooks/issues/12 \n[bug] Title: Webhook not delivered \nBody: Nothing arrives after saving the hook.",
+		},
+		{
+			fixture:    "pull_request",
+			wantType:   "pull_request",
+			wantAction: "closed",
+			wantOwner:  "bob",
+			wantUser:   "gitea",
+			want:       "gitea merged pull request gitea/webhooks #7 (main<-retry)\nTitle: Retry failed deliveries\njump: https://gitea.example.com/gitea/webhooks/pulls/7",
+		},
+		{
+			fixture:    "pull_request_comment",
+			wantType:   "issue_comment",
+			wantAction: "created",
+			wantOwner:  "gitea",
+			wantUser:   "alice",
+			want:       "alice commented on gitea/webhooks #7 \njump: https://gitea.example.com/gitea/webhooks/pulls/7#issuecomment-501 \n Title: Retry failed deliveries \nBody: Retry deliveries that failed with 5xx. \nComment: LGTM, please squash before merging. \n",
+		},
+		{
+			fixture:    "release",
+			wantType:   "release",
+			wantAction: "published",
+			wantOwner:  "gitea",
+			wantUser:   "gitea",
+			want:       "gitea published release v1.2.0 of gitea/webhooks\nName: v1.2.0\nAuthor: gitea\nNotes: Bug fixes.\nAsset: webhooks_linux_amd64.tar.gz (2.0 MB) https://gitea.example.com/attachments/8\njump: https://gitea.example.com/gitea/webhooks/releases/tag/v1.2.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "gitea", tt.fixture+".json"))
+			if err != nil {
+				t.Fatalf("read fixture err %v", err)
+			}
+			s := NewServer()
+			// 没有配置时不接收gitea的投递，只配置了路径时拒绝所有投递
+			for _, path := range []string{"", DefaultGiteaPath} {
+				s.GiteaPath = path
+				req := httptest.NewRequest(http.MethodPost, DefaultGiteaPath, bytes.NewReader(body))
+				req.Header.Set("X-Gitea-Event", tt.fixture)
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, req)
+				if w.Code == http.StatusOK {
+					t.Fatalf("path %q without secret code = %d, want rejected", path, w.Code)
+				}
+			}
+			s.GiteaSecrets = []string{"old", "new"}
+
+			// 签名不对时拒绝
+			req := httptest.NewRequest(http.MethodPost, s.GiteaPath, bytes.NewReader(body))
+			req.Header.Set("X-Gitea-Event", tt.fixture)
+			req.Header.Set("X-Gitea-Signature", giteaSign("bad", body))
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("bad signature code = %d, want %d", w.Code, http.StatusForbidden)
+			}
+
+			// forgejo 的请求头
+			req = httptest.NewRequest(http.MethodPost, s.GiteaPath, bytes.NewReader(body))
+			req.Header.Set("X-Forgejo-Event", tt.fixture)
+			req.Header.Set("X-Forgejo-Signature", giteaSign("new", body))
+			req.Header.Set("X-Forgejo-Delivery", "d-"+tt.fixture)
+			w = httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("code = %d, want %d, body %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			var event Event
+			select {
+			case event = <-s.Events:
+			case <-time.After(time.Second):
+				t.Fatalf("event not queued")
+			}
+			if event.Provider != ProviderGitea || event.Type != tt.wantType || event.Action != tt.wantAction {
+				t.Errorf("event = %s %s.%s, want gitea %s.%s", event.Provider, event.Type, event.Action, tt.wantType, tt.wantAction)
+			}
+			if event.Owner != tt.wantOwner || event.FromUser != tt.wantUser || event.DeliveryID != "d-"+tt.fixture {
+				t.Errorf("event owner %s user %s delivery %s, want %s %s d-%s", event.Owner, event.FromUser, event.DeliveryID, tt.wantOwner, tt.wantUser, tt.fixture)
+			}
+			if event.FullName() != "gitea/webhooks" {
+				t.Errorf("FullName() = %s, want gitea/webhooks", event.FullName())
+			}
+			got, err := tpl.Render(&TemplateData{Event: &event, Payload: event.Payload, MaxCommits: 5})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
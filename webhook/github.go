@@ -2,54 +2,197 @@ package webhook
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/tebeka/selenium/firefox"
-
-	"github.com/tebeka/selenium/chrome"
-
 	"github.com/scjtqs2/bot_adapter/coolq"
 	"github.com/scjtqs2/bot_adapter/pb/entity"
 
 	"github.com/scjtqs2/bot_adapter/client"
 	log "github.com/sirupsen/logrus"
-	"github.com/tebeka/selenium"
+
+	"github.com/scjtqs2/bot_app_github/config"
 )
 
 // GHook github推送类
 type GHook struct {
-	Cli                  *client.AdapterService
-	Enable               bool    // 是否启用webhook
-	NotifyQQ             int64   // 接收推送的qq
-	NotifyQQGroup        int64   // 接收推送的群
-	GithubSecret         string  // github的hook的secret
-	Server               *Server // http监听地址
-	ChromeScreenShotChan chan *chromeScreenShot
+	mu                sync.RWMutex // 保护热加载的 AdminQQ
+	Cli               *client.AdapterService
+	Enable            bool                    // 是否启用webhook
+	Router            *Router                 // 推送路由表，决定每个event推送给哪些qq和群
+	Subscriptions     *Subscriptions          // 群通过聊天命令管理的订阅
+	Templates         *Templates              // 推送消息模板
+	PushBranches      []string                // 推送push事件的分支，支持glob，为空表示全部分支
+	PushMaxCommits    int                     // push 消息最多列出的 commit 数
+	ReleaseScreenshot bool                    // release 消息是否附带发布页截图
+	Deduper           *Deduper                // 按 X-GitHub-Delivery 去重
+	Releases          *Deduper                // 按 release 去重，published 和 released、prereleased 只推送一次
+	EventLog          *EventLog               // 事件日志，重启后重新处理没处理完的event
+	CI                *CITracker              // 记录CI结果，判断失败后的恢复
+	CIFailureOnly     bool                    // CI 类event只推送失败和恢复
+	Threads           *Threads                // 同一次部署的消息串成回复链
+	AdminQQ           int64                   // 管理员qq，接收 ping 等webhook自身的通知
+	GithubSecret      string                  // github的hook的secret，多个用逗号分隔，用于轮换
+	AllowSHA1         bool                    // 是否允许已废弃的sha1签名校验
+	GitlabPath        string                  // 接收gitlab投递的路径
+	GitlabToken       string                  // gitlab的hook的secret token，多个用逗号分隔
+	GiteaPath         string                  // 接收gitea、forgejo投递的路径
+	GiteaSecret       string                  // gitea的hook的secret，多个用逗号分隔
+	PushTags          bool                    // 是否推送tag的push
+	Server            *Server                 // http监听地址
+	Renderer          Renderer                // 截图后端，未开启截图时为nil
+	RendererType      string                  // 截图后端的类型，如 selenium-chrome
+	Cards             *CardRenderer           // 没有浏览器截图时把 issue、评论画成卡片，为nil表示不画
+	Cache             *ScreenshotCache        // 截图缓存，为nil表示不缓存
+	Lanes             *Lanes                  // 按仓库分道推送，同一个仓库的消息按收到的顺序推送，不同仓库互不阻塞
+	Commits           *CommitTracker          // 异步推送时记录可以提交的事件日志偏移
+	ScreenshotWait    time.Duration           // 等待截图的时间，截图超时先推送文字，截图完成后再补发
+	SelectorRules     map[string]SelectorRule // 按页面类型配置的截图规则，覆盖内置的规则
+	MaxHeight         int                     // 截图的最大高度，超过时切成多张图片，0表示不切
+	workers           chan struct{}           // 截图的 worker，限制同时截图的数量
+	renderVariant     string                  // 截图的外观，区分缓存
+}
+
+// NewGHook 按配置初始化 ghook
+func NewGHook(cli *client.AdapterService, cfg *config.Config) *GHook {
+	w := cfg.Webhook
+	subs, err := NewSubscriptions(w.Subscriptions)
+	if err != nil {
+		log.Errorf("load webhook subscriptions from %s err:%v, subscriptions are read-only until the file is fixed", w.Subscriptions, err)
+	}
+	// 兼容旧的环境变量配置：第一次启动时把推送群迁移为订阅全部仓库，之后由群内命令管理
+	if w.NotifyGroup != 0 && !subs.Exists() {
+		if err := subs.Subscribe(w.NotifyGroup, "*/*", nil); err != nil {
+			log.Errorf("migrate GITHUB_WEBHOOK_NOTIFY_GROUP %d to subscriptions err:%v", w.NotifyGroup, err)
+		}
+	}
+	deduper, err := NewDeduper(w.DedupSize, w.DedupTTL, w.DedupFile)
+	if err != nil {
+		log.Errorf("load webhook deliveries err:%v", err)
+	}
+	// 同一次发版的几个event几乎同时发送，只在内存中记录
+	releases, _ := NewDeduper(w.DedupSize, time.Hour, "")
+	var eventLog *EventLog
+	if w.EventLog != "" {
+		if eventLog, err = OpenEventLog(w.EventLog, 1000, w.EventLogSegments); err != nil {
+			log.Errorf("open webhook event log %s err:%v", w.EventLog, err)
+		}
+	}
+	ci, err := NewCITracker(w.CIStateFile)
+	if err != nil {
+		log.Errorf("load webhook ci state err:%v", err)
+	}
+	templates, err := NewTemplates(w.Templates)
+	if err != nil {
+		log.Errorf("load webhook templates err:%v, use default templates", err)
+		templates, _ = NewTemplates("")
+	}
+	router := NewRouter()
+	router.SetSubscriptions(subs)
+	routes, err := configRoutes(w)
+	if err != nil {
+		log.Errorf("load webhook routes from %s err:%v", w.Routes, err)
+	}
+	router.Add(routes...)
+	g := &GHook{
+		Cli:               cli,
+		Enable:            w.Enable,
+		Router:            router,
+		Subscriptions:     subs,
+		Templates:         templates,
+		PushBranches:      w.PushBranches,
+		PushMaxCommits:    w.PushCommits,
+		ReleaseScreenshot: w.ReleaseScreenshot,
+		Deduper:           deduper,
+		Releases:          releases,
+		EventLog:          eventLog,
+		CI:                ci,
+		CIFailureOnly:     w.CIFailureOnly,
+		Threads:           NewThreads(),
+		AdminQQ:           w.AdminQQ,
+		GithubSecret:      w.Secret,
+		AllowSHA1:         w.AllowSHA1,
+		GitlabPath:        w.GitlabPath,
+		GitlabToken:       w.GitlabToken,
+		GiteaPath:         w.GiteaPath,
+		GiteaSecret:       w.GiteaSecret,
+		PushTags:          w.PushTags,
+		Lanes:             NewLanes(),
+		Commits:           &CommitTracker{},
+	}
+	shot := cfg.Screenshot
+	if shot.Card {
+		if g.Cards, err = NewCardRenderer(shot.CardFont); err != nil {
+			log.Errorf("load card font err:%v, use default font", err)
+			g.Cards, _ = NewCardRenderer("")
+		}
+	}
+	if shot.CacheDir != "" {
+		if g.Cache, err = NewScreenshotCache(shot.CacheDir, shot.CacheMaxMB<<20); err != nil {
+			log.Errorf("open screenshot cache %s err:%v", shot.CacheDir, err)
+			g.Cache = nil
+		}
+	}
+	renderCfg := NewRendererConfig(shot)
+	g.RendererType = renderCfg.Type
+	g.renderVariant = renderCfg.variant()
+	g.workers = make(chan struct{}, renderCfg.PoolSize)
+	g.ScreenshotWait = shot.Wait
+	g.MaxHeight = shot.MaxHeight
+	if shot.Rules != "" {
+		if g.SelectorRules, err = LoadSelectorRules(shot.Rules); err != nil {
+			log.Errorf("load screenshot rules from %s err:%v", shot.Rules, err)
+		}
+	}
+	if g.Renderer, err = NewRenderer(renderCfg); err != nil {
+		log.Errorf("init screenshot renderer err:%v", err)
+	}
+	return g
 }
 
-// chromeScreenShot selenium-chrome 截图的结果
-type chromeScreenShot struct {
-	Img []byte
-	Err error
+// configRoutes 配置中的路由规则：推送给 NotifyQQ 的全部event，加上路由表文件中的规则
+func configRoutes(w config.Webhook) ([]Route, error) {
+	var routes []Route
+	// 兼容旧的环境变量配置，全部仓库、全部event都推送
+	if w.NotifyQQ != 0 {
+		routes = append(routes, Route{QQ: []int64{w.NotifyQQ}})
+	}
+	if w.Routes == "" {
+		return routes, nil
+	}
+	file, err := LoadRoutes(w.Routes)
+	return append(routes, file...), err
 }
 
-// NewGHook 初始化 ghook
-func NewGHook(cli *client.AdapterService) *GHook {
-	qq, _ := strconv.ParseInt(os.Getenv("GITHUB_WEBHOOK_NOTIFY_QQ"), 10, 64)
-	group, _ := strconv.ParseInt(os.Getenv("GITHUB_WEBHOOK_NOTIFY_GROUP"), 10, 64)
-	return &GHook{
-		Cli:           cli,
-		Enable:        os.Getenv("GITHUB_WEBHOOK_ENABLE") == "true",
-		NotifyQQ:      qq,
-		NotifyQQGroup: group,
-		GithubSecret:  os.Getenv("GITHUB_WEBHOOK_SECRET"),
+// Reload 热加载路由规则、消息模板和管理员qq，加载失败时保留原来的配置。
+// 其他配置需要重启才能生效
+func (g *GHook) Reload(w config.Webhook) error {
+	routes, err := configRoutes(w)
+	if err != nil {
+		errmsg := fmt.Sprintf("load routes from %s: %v", w.Routes, err)
+		return errors.New(errmsg)
 	}
+	if err := g.Templates.Reload(w.Templates); err != nil {
+		errmsg := fmt.Sprintf("load templates from %s: %v", w.Templates, err)
+		return errors.New(errmsg)
+	}
+	g.Router.Set(routes...)
+	g.mu.Lock()
+	g.AdminQQ = w.AdminQQ
+	g.mu.Unlock()
+	log.Infof("github webhook reloaded routes:%d", len(g.Router.Routes()))
+	return nil
+}
+
+// adminQQ 管理员qq，热加载时会改变
+func (g *GHook) adminQQ() int64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.AdminQQ
 }
 
 // Init 初始化
@@ -58,389 +201,445 @@ func (g *GHook) Init() {
 		log.Warn("未开启github webhook")
 		return
 	}
-	log.Infof("github webhook 开启中 notifyqq:%d ,notifyGroup:%d,secret:%s", g.NotifyQQ, g.NotifyQQGroup, g.GithubSecret)
-	g.Server = NewServer()
-	g.Server.Port = 80
-	g.Server.Secret = g.GithubSecret
+	log.Infof("github webhook 开启中 routes:%d", len(g.Router.Routes()))
+	g.Server = g.newServer()
+	// 先处理上次退出时还没处理完的event，再开启监听，避免启动时收到的投递被处理两次
+	g.replayUncommitted()
 	g.Server.GoListenAndServe() // 开启监听
 	go g.parseEvents()
 }
 
-// parseEvents 处理收到的events TODO 推送
+// newServer 按配置初始化 webhook 服务
+func (g *GHook) newServer() *Server {
+	server := NewServer()
+	server.Port = 80
+	server.Secrets = ParseSecrets(g.GithubSecret)
+	server.AllowSHA1 = g.AllowSHA1
+	// gitlab 的投递需要显式开启
+	if g.GitlabPath != "" {
+		server.GitLabPath = g.GitlabPath
+	} else if g.GitlabToken != "" {
+		server.GitLabPath = DefaultGitLabPath
+	}
+	server.GitLabTokens = ParseSecrets(g.GitlabToken)
+	if server.GitLabPath != "" && len(server.GitLabTokens) == 0 {
+		log.Warnf("GITLAB_WEBHOOK_TOKEN 为空，%s 将拒绝所有gitlab的投递", server.GitLabPath)
+	}
+	if g.GiteaPath != "" {
+		server.GiteaPath = g.GiteaPath
+	} else if g.GiteaSecret != "" {
+		server.GiteaPath = DefaultGiteaPath
+	}
+	server.GiteaSecrets = ParseSecrets(g.GiteaSecret)
+	if server.GiteaPath != "" && len(server.GiteaSecrets) == 0 {
+		log.Warnf("GITEA_WEBHOOK_SECRET 为空，%s 将拒绝所有gitea的投递", server.GiteaPath)
+	}
+	server.IgnoreTags = !g.PushTags
+	server.Deduper = g.Deduper
+	server.EventLog = g.EventLog
+	return server
+}
+
+// parseEvents 处理收到的events
 func (g *GHook) parseEvents() {
 	for event := range g.Server.Events {
-		log.Infof("resived event %+v", event)
-		var msg string
-		isPic := false
-		switch event.Type {
-		case "star":
-			// Tim-Paik starred Mrs4s/go-cqhttp (total 3914 stargazers)
-			var a string
-			switch event.Action {
-			case "created":
-				a = "starred"
-			case "deleted":
-				a = "unstarred"
-			}
-			msg = fmt.Sprintf("%s %s %s/%s (total %d stargazers)", event.FromUser, a, event.Owner, event.Repo, event.Payload.Get("repository.stargazers_count").Int())
-		case "fork":
-			msg = fmt.Sprintf("%s forked %s/%s (total %d forks_count)", event.FromUser, event.Owner, event.Repo, event.Payload.Get("repository.forks_count").Int())
-		case "issues":
-			var labels string
-			for _, result := range event.Payload.Get("issue.labels").Array() {
-				labels += fmt.Sprintf("[%s]", result.Get("name").String())
-			}
+		event := event
+		g.handle(&event)
+	}
+}
 
-			switch event.Action {
-			case "opened":
-				// wdvxdr1123 opened issue Mrs4s/go-cqhttp#1358
-				msg = fmt.Sprintf("%s %s issue %s/%s #%d \n", event.FromUser, event.Action, event.Owner, event.Repo, event.Payload.Get("issue.number").Int()) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("issue.html_url").String())
-				if g.checkSelemiumEnable() {
-					if pic, err := g.getIssueByChrome(event.Payload.Get("issue.html_url").String(), event.Payload.Get("issue.id").String()); err == nil {
-						isPic = true
-						msg += coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
-					} else {
-						log.Errorf("getIssueByChrome err:%v", err)
-					}
-				}
-				if !isPic {
-					msg += fmt.Sprintf("%s Title: %s \n", labels, event.Payload.Get("issue.title").String()) +
-						fmt.Sprintf("Body: %s", event.Payload.Get("issue.body").String()) +
-						coolq.EnImageCode(fmt.Sprintf("https://opengraph.githubassets.com/0/%s/%s/issues/%d", event.Owner, event.Repo, event.Payload.Get("issue.number").Int()), 0)
-				}
-			case "closed":
-				msg = fmt.Sprintf("%s %s issue %s/%s #%d \n", event.FromUser, event.Action, event.Owner, event.Repo, event.Payload.Get("issue.number").Int()) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("issue.html_url").String())
-				if g.checkSelemiumEnable() {
-					if pic, err := g.getIssueByChrome(event.Payload.Get("issue.html_url").String(), event.Payload.Get("issue.id").String()); err == nil {
-						isPic = true
-						msg += coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
-					} else {
-						log.Errorf("getIssueByChrome err:%v", err)
-					}
-				}
-				if !isPic {
-					msg += fmt.Sprintf("%s Title: %s \n", labels, event.Payload.Get("issue.title").String()) +
-						fmt.Sprintf("Body: %s \n", event.Payload.Get("issue.body").String()) +
-						coolq.EnImageCode(fmt.Sprintf("https://opengraph.githubassets.com/0/%s/%s/issues/%d", event.Owner, event.Repo, event.Payload.Get("issue.number").Int()), 0)
-				}
-			case "reopened":
-				msg = fmt.Sprintf("%s %s issue %s/%s #%d \n", event.FromUser, event.Action, event.Owner, event.Repo, event.Payload.Get("issue.number").Int()) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("issue.html_url").String())
-				if g.checkSelemiumEnable() {
-					if pic, err := g.getIssueByChrome(event.Payload.Get("issue.html_url").String(), event.Payload.Get("issue.id").String()); err == nil {
-						isPic = true
-						msg += coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
-					} else {
-						log.Errorf("getIssueByChrome err:%v", err)
-					}
-				}
-				if !isPic {
-					msg += fmt.Sprintf("%s Title: %s \n", labels, event.Payload.Get("issue.title").String()) +
-						fmt.Sprintf("Body: %s", event.Payload.Get("issue.body").String()) +
-						coolq.EnImageCode(fmt.Sprintf("https://opengraph.githubassets.com/0/%s/%s/issues/%d", event.Owner, event.Repo, event.Payload.Get("issue.number").Int()), 0)
-				}
-			}
-		case "issue_comment":
-			var labels string
-			for _, result := range event.Payload.Get("issue.labels").Array() {
-				labels += fmt.Sprintf("[%s]", result.Get("name").String())
-			}
-			switch event.Action {
-			case "created":
-				msg = fmt.Sprintf("%s commented on %s/%s #%d \n", event.FromUser, event.Owner, event.Repo, event.Payload.Get("issue.number").Int()) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("comment.html_url").String())
-				if g.checkSelemiumEnable() {
-					if pic, err := g.getIssueCommentByChrome(event.Payload.Get("comment.html_url").String(), event.Payload.Get("comment.id").String()); err == nil {
-						isPic = true
-						msg += coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
-					} else {
-						log.Errorf("getIssueCommentByChrome err:%v", err)
-					}
-				}
-				if !isPic {
-					msg += fmt.Sprintf("%s Title: %s \n", labels, event.Payload.Get("issue.title").String()) +
-						fmt.Sprintf("Body: %s \n", event.Payload.Get("issue.body").String()) +
-						fmt.Sprintf("Comment: %s \n", event.Payload.Get("comment.body").String())
-				}
-			case "edited":
-				msg = fmt.Sprintf("%s edited commente on %s/%s #%d \n", event.FromUser, event.Owner, event.Repo, event.Payload.Get("issue.number").Int()) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("comment.html_url").String())
-				if g.checkSelemiumEnable() {
-					if pic, err := g.getIssueCommentByChrome(event.Payload.Get("comment.html_url").String(), event.Payload.Get("comment.id").String()); err == nil {
-						isPic = true
-						msg += coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
-					} else {
-						log.Errorf("getIssueCommentByChrome err:%v", err)
-					}
-				}
-				if !isPic {
-					msg += fmt.Sprintf("%s Title: %s \n", labels, event.Payload.Get("issue.title").String()) +
-						fmt.Sprintf("Body: %s \n", event.Payload.Get("issue.body").String()) +
-						fmt.Sprintf("Comment: %s \n", event.Payload.Get("comment.body").String())
-				}
-			case "deleted":
-				msg = fmt.Sprintf("%s deleted commente on %s/%s #%d", event.FromUser, event.Owner, event.Repo, event.Payload.Get("issue.number").Int()) +
-					fmt.Sprintf("%s Title: %s \n", labels, event.Payload.Get("issue.title").String()) +
-					fmt.Sprintf("Body: %s \n", event.Payload.Get("issue.body").String()) +
-					fmt.Sprintf("Comment: %s \n", event.Payload.Get("comment.body").String()) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("comment.html_url").String())
-			}
-		case "pull_request":
-			switch event.Action {
-			case "opened":
-				// wdvxdr1123 opened an pull request for Mrs4s/go-cqhttp#1356(dev<wdvxdr1123:test_pr_review)
-				msg = fmt.Sprintf("%s opened an pull request for %s/%s #%d (%s<-%s:%s) \n", event.FromUser, event.Owner, event.Repo,
-					event.Payload.Get("pull_request.number").Int(),
-					event.BaseBranch, event.Owner, event.Branch) +
-					fmt.Sprintf("jump: %s \n", event.Payload.Get("pull_request.html_url").String())
-				if g.checkChromeEnable() {
-					if pic, err := g.getPullRequestByChrome(event.Payload.Get("pull_request.html_url").String()); err == nil {
-						isPic = true
-						msg += coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
-					} else {
-						log.Errorf("getPullRequestByChrome err:%v", err)
-					}
-				}
-				if !isPic {
-					msg += coolq.EnImageCode(fmt.Sprintf("https://opengraph.githubassets.com/0/%s/%s/pull/%d", event.BaseOwner, event.BaseRepo, event.Payload.Get("pull_request.number").Int()), 0)
-				}
-			default:
-				continue
-			}
-		default:
-			log.Warnf("unknow eventType:%s,action:%s from %s/%s", event.Type, event.Action, event.Owner, event.Repo)
-			continue
+// handle 过滤event并记录CI、消息串的状态，需要推送的交给 deliver 异步截图、推送。
+// 处理完成后记录事件日志的偏移
+func (g *GHook) handle(event *Event) {
+	log.Infof("resived event %+v", event)
+	g.Commits.Start(event.Offset)
+	delivering := false
+	defer func() {
+		if !delivering {
+			g.commit(event.Offset)
 		}
-		if msg == "" {
-			continue
+	}()
+	if event.Type == "ping" && g.adminQQ() == 0 {
+		log.Infof("webhook ping hook_id:%d from %s", event.Payload.Get("hook_id").Int(), event.FullName())
+		return
+	}
+	ci := NewCIStatus(event)
+	if ci != nil {
+		// github actions 的 check_suite、check_run 和 workflow_run、workflow_job 重复
+		if ci.App == "github-actions" {
+			return
 		}
-		if g.NotifyQQ != 0 {
-			_, err := g.Cli.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
-				UserId:  g.NotifyQQ,
-				Message: []byte(msg),
-			})
-			if err != nil {
-				log.Errorf("push to NotifyQQ %d err:%v", g.NotifyQQ, err)
-			}
+		if err := g.CI.Update(event.FullName(), ci); err != nil {
+			log.Errorf("save ci state err:%v", err)
 		}
-		if g.NotifyQQGroup != 0 {
-			_, err := g.Cli.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{GroupId: g.NotifyQQGroup, Message: []byte(msg)})
-			if err != nil {
-				log.Errorf("push to NotifyQQGroup %d err:%v", g.NotifyQQGroup, err)
-			}
+		if g.CIFailureOnly && !ci.Failed && !ci.Recovered {
+			log.Debugf("skip ci %s.%s %s of %s", event.Type, event.Action, ci.Conclusion, event.FullName())
+			return
 		}
 	}
+	if !g.Templates.Has(event.Type, event.Action) {
+		log.Warnf("unknow eventType:%s,action:%s from %s/%s", event.Type, event.Action, event.Owner, event.Repo)
+		return
+	}
+	if g.duplicateRelease(event) {
+		log.Debugf("skip duplicate release.%s %s of %s", event.Action, event.Tag, event.FullName())
+		return
+	}
+	if event.Type == "push" && event.Branch != "" && !matchBranch(g.PushBranches, event.Branch) {
+		log.Debugf("skip push to branch %s of %s", event.Branch, event.FullName())
+		return
+	}
+	var states []string
+	if event.Type == "deployment_status" {
+		states = g.Threads.Transition(event.ThreadKey(), event.Payload.Get("deployment_status.state").String())
+	}
+	delivering = true
+	g.deliver(event, &TemplateData{
+		Event:      event,
+		Payload:    event.Payload,
+		MaxCommits: g.PushMaxCommits,
+		CI:         ci,
+		States:     states,
+	})
 }
 
-// checkSelemiumEnable 判断是否开启了 chrome或者firefox
-func (g *GHook) checkSelemiumEnable() bool {
-	check := g.checkChromeEnable()
-	if check {
-		return true
+// duplicateRelease 发版时 github 会同时发送 published 和 released（预发布为 prereleased），同一个 release 只推送一次。
+// 预发布转为正式版时只发送 released，按 release id 和是否预发布区分
+func (g *GHook) duplicateRelease(event *Event) bool {
+	if g.Releases == nil || event.Type != "release" {
+		return false
+	}
+	switch event.Action {
+	case "published", "prereleased", "released":
+	default:
+		return false
 	}
-	return g.checkFirefoxEnable()
+	p := event.Payload
+	key := event.FullName() + "#" + p.Get("release.id").String() + ":" + strconv.FormatBool(p.Get("release.prerelease").Bool())
+	return g.Releases.Seen(key)
 }
 
-// checkChromeEnable 判断是否启用了selenuimChrome开关
-func (g *GHook) checkChromeEnable() bool {
-	return os.Getenv("SELENIUM_CHROME_ENABLE") == "true"
+// deliver 截图交给 worker 在后台执行，推送按仓库排队，同一个仓库的消息按收到的顺序推送。
+// 截图在 ScreenshotWait 之内完成时和文字一起推送，否则先推送文字，截图完成后回复文字消息补发
+func (g *GHook) deliver(event *Event, data *TemplateData) {
+	shot := g.goScreenshot(event)
+	lane := event.FullName()
+	g.Lanes.Go(lane, func() {
+		if shot == nil {
+			g.send(event, data)
+			g.commit(event.Offset)
+			return
+		}
+		if pic, ok := waitScreenshot(shot, g.ScreenshotWait); ok {
+			data.Screenshot = pic
+			data.Screenshots = g.splitScreenshot(pic)
+			g.send(event, data)
+			g.commit(event.Offset)
+			return
+		}
+		ids := g.send(event, data)
+		// 补发的截图单独排队，不阻塞同一个仓库后面的文字消息
+		g.Lanes.Go(lane+"#screenshot", func() {
+			defer g.commit(event.Offset)
+			if pic := <-shot; pic != nil {
+				g.followUp(ids, pic)
+			}
+		})
+	})
+}
+
+// send 按模板渲染后推送，返回每个推送目标收到的消息id
+func (g *GHook) send(event *Event, data *TemplateData) map[string]int64 {
+	msg, err := g.Templates.Render(data)
+	if err != nil {
+		log.Errorf("render template %s.%s err:%v", event.Type, event.Action, err)
+		return nil
+	}
+	if msg == "" {
+		return nil
+	}
+	if event.Type == "ping" {
+		g.notifyAdmin(msg)
+		return nil
+	}
+	return g.notify(event, msg)
 }
 
-// checkChromeEnable 判断是否启用了seleniumFirefox开关
-func (g *GHook) checkFirefoxEnable() bool {
-	return os.Getenv("SELENIUM_FIREFOX_ENABLE") == "true"
+// followUp 截图完成后补发给已经收到文字消息的目标，回复对应的文字消息
+func (g *GHook) followUp(ids map[string]int64, pic []byte) {
+	var images string
+	for _, part := range g.splitScreenshot(pic) {
+		images += imageBase64Code(part)
+	}
+	for target, id := range ids {
+		msg := images
+		if id != 0 {
+			msg = coolq.EnReplyCode(int(id)) + msg
+		}
+		if _, err := g.sendTo(target, msg); err != nil {
+			log.Errorf("push screenshot to %s err:%v", target, err)
+		}
+	}
 }
 
-// getIssueByChrome 通过chrome截图获取 issue详情
-func (g *GHook) getIssueByChrome(url string, issueID string) ([]byte, error) {
-	log.Debugf("url:%s , issueID:%s", url, issueID)
-	wd, err := g.newSelnium()
-	if err != nil {
-		return nil, err
+// commit 记录event处理完成，提交更早的event都已处理完的事件日志偏移
+func (g *GHook) commit(offset int64) {
+	if g.EventLog == nil || offset <= 0 {
+		return
 	}
-	defer func() {
-		// _ = wd.Close()
-		_ = wd.Quit()
-	}()
-	if err := wd.Get(url); err != nil {
-		return nil, err
+	if err := g.EventLog.Commit(g.Commits.Done(offset)); err != nil {
+		log.Errorf("commit event log offset %d err:%v", offset, err)
 	}
-	_ = wd.Wait(func(wd selenium.WebDriver) (bool, error) {
-		_, err = wd.FindElement(selenium.ByCSSSelector, "#js-repo-pjax-container")
-		return err == nil, nil
+}
+
+// replayUncommitted 重新处理事件日志中还没有处理完的event
+func (g *GHook) replayUncommitted() {
+	if g.EventLog == nil {
+		return
+	}
+	from := g.EventLog.Committed() + 1
+	err := g.EventLog.Scan(from, func(r *Record) error {
+		g.replayRecord(r, true)
+		return nil
 	})
-	issue, err := wd.FindElement(selenium.ByCSSSelector, "#js-repo-pjax-container")
 	if err != nil {
-		return nil, err
+		log.Errorf("replay event log from %d err:%v", from, err)
 	}
-	// issue, err := wd.FindElement(selenium.ByCSSSelector, fmt.Sprintf("#issue-%s > div", issueID))
-	// if err != nil {
-	// 	return nil, err
-	// }
-	sidebar, err := issue.FindElement(selenium.ByCSSSelector, "#partial-discussion-sidebar")
-	if err == nil {
-		_, _ = wd.ExecuteScript("arguments[0].remove();", []interface{}{sidebar})
+}
+
+// Replay 按条件重新处理事件日志中的event，不提交偏移，等推送完成后返回，用于 replay 命令
+func (g *GHook) Replay(filter *ReplayFilter) (int, error) {
+	if g.EventLog == nil {
+		return 0, errors.New("event log not enabled, set GITHUB_WEBHOOK_EVENT_LOG")
 	}
-	signBar, err := issue.FindElement(selenium.ByCSSSelector, ".discussion-timeline-actions")
-	if err == nil {
-		_, _ = wd.ExecuteScript("arguments[0].remove();", []interface{}{signBar})
+	if g.Server == nil {
+		g.Server = g.newServer()
 	}
-	size, err := issue.Size()
+	var n int
+	err := g.EventLog.Scan(0, func(r *Record) error {
+		if !filter.Match(r) {
+			return nil
+		}
+		n++
+		g.replayRecord(r, false)
+		return nil
+	})
+	// 推送是异步的，等推送完再返回，否则 replay 命令退出时还没有推送
+	g.Lanes.Wait()
+	return n, err
+}
+
+// replayRecord 解析并处理事件日志中的一条记录，commit 为 false 时处理完不提交偏移
+func (g *GHook) replayRecord(r *Record, commit bool) {
+	event, err := g.Server.EventFromRecord(r)
 	if err != nil {
-		return nil, err
+		log.Warnf("replay delivery %s offset %d err:%v", r.DeliveryID(), r.Offset, err)
+		return
+	}
+	log.Infof("replay delivery %s offset %d", event.DeliveryID, event.Offset)
+	if !commit {
+		// 偏移为0时不记录也不提交，手动 replay 旧的投递不能跳过还没处理的记录
+		event.Offset = 0
 	}
-	window, _ := wd.CurrentWindowHandle()
-	_ = wd.ResizeWindow(window, size.Width, size.Height+100)
-	return issue.Screenshot(false)
+	g.handle(event)
 }
 
-// getIssueCommentByChrome 通过chrome获取issueComment的截图
-func (g *GHook) getIssueCommentByChrome(url string, issueCommentID string) ([]byte, error) {
-	wd, err := g.newSelnium()
+// splitScreenshot 按最大高度把截图切成多张
+func (g *GHook) splitScreenshot(pic []byte) [][]byte {
+	parts, err := SplitImage(pic, g.MaxHeight)
 	if err != nil {
-		return nil, err
+		log.Errorf("split screenshot err:%v", err)
+		return [][]byte{pic}
 	}
-	defer func() {
-		// _ = wd.Close()
-		_ = wd.Quit()
+	return parts
+}
+
+// goScreenshot 在后台截图，返回接收截图结果的channel，不需要截图时返回nil
+func (g *GHook) goScreenshot(event *Event) <-chan []byte {
+	if !g.needScreenshot(event) {
+		return nil
+	}
+	shot := make(chan []byte, 1)
+	go func() {
+		g.workers <- struct{}{}
+		defer func() { <-g.workers }()
+		shot <- g.screenshot(event)
 	}()
-	if err := wd.Get(url); err != nil {
-		return nil, err
+	return shot
+}
+
+// needScreenshot event是否需要截图或画卡片
+func (g *GHook) needScreenshot(event *Event) bool {
+	if g.Renderer != nil && event.IsGitHub() && g.renderRequest(event) != nil {
+		return true
 	}
-	_ = wd.Wait(func(wd selenium.WebDriver) (bool, error) {
-		_, err = wd.FindElement(selenium.ByCSSSelector, "#js-repo-pjax-container")
-		return err == nil, nil
-	})
-	time.Sleep(time.Second * 5)
-	sizeEle, err := wd.FindElement(selenium.ByCSSSelector, "#js-repo-pjax-container")
-	if err != nil {
-		return nil, err
+	return g.Cards != nil && g.Cards.Supports(event)
+}
+
+// screenshot 按event类型截取页面，没有配置浏览器或截图失败时画成卡片，不需要截图时返回nil
+func (g *GHook) screenshot(event *Event) []byte {
+	if pic := g.browserScreenshot(event); pic != nil {
+		return pic
 	}
-	// comment, err := wd.FindElement(selenium.ByCSSSelector, fmt.Sprintf("#issuecomment-%s > div", issueCommentID))
-	_ = wd.Wait(func(wd selenium.WebDriver) (bool, error) {
-		_, err = wd.FindElement(selenium.ByXPATH, fmt.Sprintf("//*[@id=\"issuecomment-%s\"]/../../..", issueCommentID))
-		return err == nil, nil
-	})
-	comment, err := wd.FindElement(selenium.ByXPATH, fmt.Sprintf("//*[@id=\"issuecomment-%s\"]/../../..", issueCommentID))
-	if err != nil {
-		return nil, err
+	if g.Cards == nil {
+		return nil
 	}
-	size, err := sizeEle.Size()
+	pic, err := g.Cards.Render(event)
 	if err != nil {
-		return nil, err
+		log.Errorf("render card %s.%s err:%v", event.Type, event.Action, err)
+		return nil
 	}
-	window, _ := wd.CurrentWindowHandle()
-	_ = wd.ResizeWindow(window, size.Width, size.Height+100)
-	time.Sleep(time.Second * 5)
-	return comment.Screenshot(false)
+	return pic
 }
 
-// getPullRequestByChrome 用于获取pullRequest的界面截图
-func (g *GHook) getPullRequestByChrome(url string) ([]byte, error) {
-	wd, err := g.newSelnium()
-	if err != nil {
-		return nil, err
+// browserScreenshot 通过浏览器截取页面，未开启截图、不需要截图或截图失败时返回nil
+func (g *GHook) browserScreenshot(event *Event) []byte {
+	// 截图的页面元素都是按github的页面写的
+	if g.Renderer == nil || !event.IsGitHub() {
+		return nil
 	}
-	defer func() {
-		// _ = wd.Close()
-		_ = wd.Quit()
-	}()
-	if err := wd.Get(url); err != nil {
-		return nil, err
+	req := g.renderRequest(event)
+	if req == nil {
+		return nil
+	}
+	render := func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+		defer cancel()
+		return g.Renderer.Render(ctx, req)
+	}
+	var (
+		pic []byte
+		err error
+	)
+	if key := req.cacheKey(); g.Cache != nil && key != "" {
+		pic, err = g.Cache.GetOrRender(key, render)
+	} else {
+		pic, err = render()
+	}
+	if errors.Is(err, ErrPageUnavailable) {
+		log.Warnf("screenshot %s.%s %s: %v, private repository needs SCREENSHOT_COOKIE or SCREENSHOT_PROFILE_DIR", event.Type, event.Action, req.URL, err)
+		return nil
 	}
-	_ = wd.Wait(func(wd selenium.WebDriver) (bool, error) {
-		_, err = wd.FindElement(selenium.ByCSSSelector, "#js-repo-pjax-container")
-		return err == nil, nil
-	})
-	pullRequest, err := wd.FindElement(selenium.ByCSSSelector, "#js-repo-pjax-container")
 	if err != nil {
-		return nil, err
+		log.Errorf("screenshot %s.%s %s err:%v", event.Type, event.Action, req.URL, err)
+		return nil
 	}
-	sidebar, err := pullRequest.FindElement(selenium.ByCSSSelector, "#partial-discussion-sidebar")
-	if err == nil {
-		_, _ = wd.ExecuteScript("arguments[0].remove();", []interface{}{sidebar})
+	return pic
+}
+
+// renderRequest 按event类型生成截图请求，不需要截图时返回nil
+func (g *GHook) renderRequest(event *Event) *RenderRequest {
+	p := event.Payload
+	switch {
+	case event.Type == "issues" && (event.Action == "opened" || event.Action == "closed" || event.Action == "reopened"):
+		return g.pageRequest(PageIssue, p.Get("issue.html_url").String(), "", p.Get("issue.updated_at").String())
+	case event.Type == "issue_comment" && (event.Action == "created" || event.Action == "edited"):
+		return g.pageRequest(PageIssueComment, p.Get("comment.html_url").String(), p.Get("comment.id").String(), p.Get("comment.updated_at").String())
+	case event.Type == "pull_request" && event.Action == "opened":
+		// firefox 截取 pull request 页面有问题，只用 chrome 截图
+		if g.RendererType == RendererSeleniumFirefox {
+			return nil
+		}
+		return g.pageRequest(PagePullRequest, p.Get("pull_request.html_url").String(), "", p.Get("pull_request.updated_at").String())
+	case event.Type == "pull_request_review_comment" && event.Action == "created":
+		return g.pageRequest(PageReviewComment, p.Get("comment.html_url").String(), p.Get("comment.id").String(), p.Get("comment.updated_at").String())
+	case event.Type == "release" && (event.Action == "published" || event.Action == "prereleased" || event.Action == "released"):
+		if !g.ReleaseScreenshot {
+			return nil
+		}
+		return g.pageRequest(PageRelease, p.Get("release.html_url").String(), "", p.Get("release.published_at").String())
 	}
-	signBar, err := pullRequest.FindElement(selenium.ByCSSSelector, ".discussion-timeline-actions")
-	if err == nil {
-		_, _ = wd.ExecuteScript("arguments[0].remove();", []interface{}{signBar})
+	return nil
+}
+
+// pageRequest 按页面类型的截图规则生成截图请求
+func (g *GHook) pageRequest(page, url, id, version string) *RenderRequest {
+	req := selectorRule(g.SelectorRules, page).request(url, id, version)
+	req.Variant = g.renderVariant
+	return req
+}
+
+// notifyAdmin 推送给管理员qq
+func (g *GHook) notifyAdmin(msg string) {
+	adminQQ := g.adminQQ()
+	if adminQQ == 0 {
+		return
 	}
-	size, err := pullRequest.Size()
+	_, err := g.Cli.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
+		UserId:  adminQQ,
+		Message: []byte(msg),
+	})
 	if err != nil {
-		return nil, err
+		log.Errorf("push to admin qq %d err:%v", adminQQ, err)
 	}
-	window, _ := wd.CurrentWindowHandle()
-	_ = wd.ResizeWindow(window, size.Width, size.Height+100)
-	return pullRequest.Screenshot(false)
 }
 
-// newSelnium 初始化 webdriver,根据配置进行自动初始化
-func (g *GHook) newSelnium() (selenium.WebDriver, error) {
-	if !g.checkSelemiumEnable() {
-		return nil, errors.New("selenium not enabled")
+// notify 按路由表把消息推送给对应的qq和群，返回每个推送目标收到的消息id。
+// 属于同一个消息串的event，后续消息会回复该目标收到的第一条消息
+func (g *GHook) notify(event *Event, msg string) map[string]int64 {
+	qqs, groups := g.Router.Match(event)
+	if len(qqs) == 0 && len(groups) == 0 {
+		log.Debugf("no route for event %s.%s from %s", event.Type, event.Action, event.FullName())
+		return nil
+	}
+	targets := make([]string, 0, len(qqs)+len(groups))
+	for _, qq := range qqs {
+		targets = append(targets, "qq:"+strconv.FormatInt(qq, 10))
 	}
+	for _, group := range groups {
+		targets = append(targets, "group:"+strconv.FormatInt(group, 10))
+	}
+	key := event.ThreadKey()
+	ids := make(map[string]int64, len(targets))
+	for _, target := range targets {
+		rsp, err := g.sendTo(target, g.threadReply(key, target)+msg)
+		if err != nil {
+			log.Errorf("push to %s err:%v", target, err)
+			continue
+		}
+		g.setThreadReply(key, target, rsp)
+		ids[target] = rsp.GetMessageId()
+	}
+	return ids
+}
 
-	var (
-		wd  selenium.WebDriver
-		err error
-	)
-	if g.checkChromeEnable() {
-		wd, err = g.newChrome()
+// sendTo 推送给 qq:号码 或 group:群号 形式的目标
+func (g *GHook) sendTo(target, msg string) (*entity.SendMsgRsp, error) {
+	parts := strings.SplitN(target, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid target " + target)
 	}
-	if g.checkFirefoxEnable() {
-		wd, err = g.newFirefox()
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid target " + target)
 	}
-	return wd, err
+	if parts[0] == "group" {
+		return g.Cli.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
+			GroupId: id,
+			Message: []byte(msg),
+		})
+	}
+	return g.Cli.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
+		UserId:  id,
+		Message: []byte(msg),
+	})
 }
 
-// newChrome 初始化chrome的webdriver
-func (g *GHook) newChrome() (selenium.WebDriver, error) {
-	if !g.checkChromeEnable() {
-		return nil, errors.New("chrome not enabled")
-	}
-	addr := os.Getenv("SELENIUM_CHROME_ADDR")
-	selenium.HTTPClient = &http.Client{
-		Timeout: time.Second * 30,
-	}
-	caps := selenium.Capabilities{"browserName": "chrome"}
-	// chrome参数
-	chromeCaps := chrome.Capabilities{
-		Args: []string{
-			"--headless", // 设置Chrome无头模式，在linux下运行，需要设置这个参数，否则会报错
-			"--disable-gpu",
-			// "--no-sandbox",
-			"--window-size=600,812",
-			// fmt.Sprintf("--proxy-server=%s", "http://192.168.28.101:7890"), // --proxy-server=http://127.0.0.1:1234
-		},
-		W3C: true,
-	}
-	caps.AddChrome(chromeCaps)
-	wd, err := selenium.NewRemote(caps, addr)
-	return wd, err
+// threadReply 消息串中回复第一条消息的CQ码，不属于消息串或还没有第一条消息时为空
+func (g *GHook) threadReply(key, target string) string {
+	if key == "" {
+		return ""
+	}
+	if id := g.Threads.ReplyTo(key, target); id != 0 {
+		return coolq.EnReplyCode(int(id))
+	}
+	return ""
 }
 
-// newFirefox 初始化firefox的webdriver
-func (g *GHook) newFirefox() (selenium.WebDriver, error) {
-	if !g.checkFirefoxEnable() {
-		return nil, errors.New("firefox not enabled")
-	}
-	addr := os.Getenv("SELENIUM_FIREFOX_ADDR")
-	selenium.HTTPClient = &http.Client{
-		Timeout: time.Second * 60,
-	}
-	caps := selenium.Capabilities{"browserName": "firefox"}
-	// firefox 参数
-	firefoxCaps := firefox.Capabilities{
-		Args: []string{
-			"--headless", // 设置Chrome无头模式，在linux下运行，需要设置这个参数，否则会报错
-			// "--disable-gpu",
-			"window-size=600,812",
-			// "--no-sandbox",
-			// fmt.Sprintf("--proxy-server=%s", "http://192.168.28.101:7890"), // --proxy-server=http://127.0.0.1:1234
-		},
-	}
-	caps.AddFirefox(firefoxCaps)
-	wd, err := selenium.NewRemote(caps, addr)
-	return wd, err
+// setThreadReply 记录消息串中的第一条消息
+func (g *GHook) setThreadReply(key, target string, rsp *entity.SendMsgRsp) {
+	if key == "" || rsp == nil {
+		return
+	}
+	g.Threads.SetReply(key, target, rsp.MessageId)
 }
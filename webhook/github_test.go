@@ -1,13 +1,27 @@
 package webhook
 
 import (
+	"context"
+	"fmt"
 	"github.com/tebeka/selenium"
 	"github.com/tebeka/selenium/chrome"
 	"github.com/tebeka/selenium/firefox"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/scjtqs2/bot_adapter/client"
+	"github.com/scjtqs2/bot_adapter/pb/entity"
+	"github.com/scjtqs2/bot_adapter/pb/service"
+	"google.golang.org/grpc"
+
+	"github.com/scjtqs2/bot_app_github/config"
 )
 
 // TestChrome 测试 chrome
@@ -134,3 +148,128 @@ func TestFirefox(t *testing.T) {
 	}
 	t.Logf("pic %v", pic)
 }
+
+// TestReload 测试热加载路由规则、模板和管理员qq，加载失败时保留原来的配置
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	routes := filepath.Join(dir, "routes.json")
+	_ = os.WriteFile(routes, []byte(`[{"repos":["octocat/*"],"groups":[100]}]`), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "star.tmpl"), []byte("star {{.Event.FullName}}\n"), 0o644)
+	templates, _ := NewTemplates("")
+	g := &GHook{Router: NewRouter(Route{QQ: []int64{1}}), Templates: templates}
+	if err := g.Reload(config.Webhook{Routes: routes, Templates: dir, NotifyQQ: 2, AdminQQ: 3}); err != nil {
+		t.Fatalf("Reload err %v", err)
+	}
+	s := NewServer()
+	event, _ := s.parseEvent("star", []byte(`{"action":"created","repository":{"name":"hello","full_name":"octocat/hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}}`))
+	if qq, groups := g.Router.Match(event); !reflect.DeepEqual(qq, []int64{2}) || !reflect.DeepEqual(groups, []int64{100}) {
+		t.Errorf("Match() = %v %v, want [2] [100]", qq, groups)
+	}
+	if msg, _ := g.Templates.Render(&TemplateData{Event: event, Payload: event.Payload}); msg != "star octocat/hello" {
+		t.Errorf("Render() = %q", msg)
+	}
+	if g.adminQQ() != 3 {
+		t.Errorf("adminQQ() = %d, want 3", g.adminQQ())
+	}
+	if err := g.Reload(config.Webhook{Routes: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Errorf("Reload() should fail with missing routes")
+	}
+	if len(g.Router.Routes()) != 2 || g.adminQQ() != 3 {
+		t.Errorf("failed Reload() should keep old config, routes %v", g.Router.Routes())
+	}
+}
+
+// fakeAdapter 记录推送消息的 bot-adapter grpc 服务
+type fakeAdapter struct {
+	service.UnimplementedAdapterServiceServer
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (f *fakeAdapter) GetAuthToken(context.Context, *entity.GetAuthTokenReq) (*entity.GetAuthTokenRsp, error) {
+	return &entity.GetAuthTokenRsp{Token: "token"}, nil
+}
+
+func (f *fakeAdapter) SendPrivateMsg(_ context.Context, req *entity.SendPrivateMsgReq) (*entity.SendMsgRsp, error) {
+	// 模拟推送的耗时
+	time.Sleep(50 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.msgs = append(f.msgs, fmt.Sprintf("qq:%d %s", req.UserId, req.Message))
+	return &entity.SendMsgRsp{MessageId: int64(len(f.msgs))}, nil
+}
+
+// TestReplay 测试 replay 返回前已经推送完，并且不提交偏移
+func TestReplay(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err %v", err)
+	}
+	adapter := &fakeAdapter{}
+	server := grpc.NewServer()
+	service.RegisterAdapterServiceServer(server, adapter)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+	cli, err := client.NewAdapterServiceClient(lis.Addr().String(), "appid", "secret")
+	if err != nil {
+		t.Fatalf("NewAdapterServiceClient err %v", err)
+	}
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Webhook.Subscriptions = filepath.Join(dir, "subscriptions.json")
+	cfg.Webhook.EventLog = filepath.Join(dir, "events")
+	cfg.Webhook.NotifyQQ = 10001
+	cfg.Screenshot.Card = false
+	g := NewGHook(cli, cfg)
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "star")
+	header.Set("X-GitHub-Delivery", "d-1")
+	body := []byte(`{"action":"created","repository":{"name":"hello","full_name":"octocat/hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}}`)
+	if _, err := g.EventLog.Append("/postreceive", header, body); err != nil {
+		t.Fatalf("Append err %v", err)
+	}
+	// replay 命令只读打开事件日志，不提交偏移
+	if g.EventLog, err = OpenEventLogReadOnly(cfg.Webhook.EventLog); err != nil {
+		t.Fatalf("OpenEventLogReadOnly err %v", err)
+	}
+	n, err := g.Replay(&ReplayFilter{DeliveryID: "d-1"})
+	if err != nil || n != 1 {
+		t.Fatalf("Replay() = %d, %v, want 1", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Webhook.EventLog, offsetFile)); !os.IsNotExist(err) {
+		t.Errorf("Replay() should not commit offset, stat err %v", err)
+	}
+	adapter.mu.Lock()
+	defer adapter.mu.Unlock()
+	if len(adapter.msgs) != 1 || !strings.HasPrefix(adapter.msgs[0], "qq:10001 ") || !strings.Contains(adapter.msgs[0], "octocat/hello") {
+		t.Errorf("sent messages = %q, want one star message to qq:10001", adapter.msgs)
+	}
+}
+
+// TestDuplicateRelease 测试同一次发版只推送一次，预发布转为正式版时推送 released
+func TestDuplicateRelease(t *testing.T) {
+	releases, _ := NewDeduper(100, time.Hour, "")
+	g := &GHook{Releases: releases}
+	s := NewServer()
+	release := func(action string, prerelease bool) *Event {
+		event, _ := s.parseEvent("release", []byte(fmt.Sprintf(`{"action":%q,"release":{"id":1,"tag_name":"v1","prerelease":%t},"repository":{"name":"hello","owner":{"login":"octocat"}}}`, action, prerelease)))
+		return event
+	}
+	tests := []struct {
+		action     string
+		prerelease bool
+		want       bool
+	}{
+		{"published", true, false},
+		{"prereleased", true, true},
+		{"released", false, false},
+		{"published", false, true},
+		{"edited", false, false},
+	}
+	for _, tt := range tests {
+		if got := g.duplicateRelease(release(tt.action, tt.prerelease)); got != tt.want {
+			t.Errorf("duplicateRelease(%s, prerelease %t) = %t, want %t", tt.action, tt.prerelease, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// 事件来源
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// DefaultGitLabPath 配置了 gitlab token 但没有配置路径时接收gitlab投递的路径
+const DefaultGitLabPath = "/gitlab"
+
+// ErrGitLabToken X-Gitlab-Token 校验不通过
+var ErrGitLabToken = errors.New("X-Gitlab-Token verification failed")
+
+// obj 构造 github 格式 payload 时用的json对象
+type obj map[string]interface{}
+
+// gitlabEvents gitlab 的 X-Gitlab-Event 对应的 github event 类型
+var gitlabEvents = map[string]string{
+	"Push Hook":          "push",
+	"Tag Push Hook":      "push",
+	"Merge Request Hook": "pull_request",
+	"Issue Hook":         "issues",
+	"Note Hook":          "issue_comment",
+	"Pipeline Hook":      "workflow_run",
+}
+
+// serveGitLab 处理 gitlab 的投递
+func (s *Server) serveGitLab(w http.ResponseWriter, req *http.Request) {
+	gitlabEvent := req.Header.Get("X-Gitlab-Event")
+	if gitlabEvent == "" {
+		writeError(w, http.StatusBadRequest, "400 Bad Request - Missing X-Gitlab-Event Header")
+		return
+	}
+	if _, ok := gitlabEvents[gitlabEvent]; !ok {
+		writeError(w, http.StatusBadRequest, "400 Bad Request - Unknown Event Type "+gitlabEvent)
+		return
+	}
+	if !s.verifyGitLabToken(req.Header.Get("X-Gitlab-Token")) {
+		writeError(w, http.StatusForbidden, "403 Forbidden - "+ErrGitLabToken.Error())
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.accept(w, req, gitlabEvents[gitlabEvent], gitlabDeliveryID(req.Header), body, func() (*Event, error) {
+		return s.parseGitLabEvent(gitlabEvent, body)
+	})
+}
+
+// gitlabDeliveryID gitlab 每次投递的唯一id
+func gitlabDeliveryID(header http.Header) string {
+	if id := header.Get("X-Gitlab-Event-UUID"); id != "" {
+		return id
+	}
+	return header.Get("X-Gitlab-Webhook-UUID")
+}
+
+// verifyGitLabToken 校验 X-Gitlab-Token，没有配置token时拒绝，避免伪造的投递绕过github的签名校验
+func (s *Server) verifyGitLabToken(token string) bool {
+	for _, t := range s.GitLabTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGitLabEvent 把 gitlab 的 payload 转换成 github 格式，这样可以共用推送模板。
+// 原始的 payload 放在转换后的 payload 的 gitlab 字段中
+func (s *Server) parseGitLabEvent(gitlabEvent string, body []byte) (*Event, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, ErrInvalidJSON
+	}
+	request := gjson.ParseBytes(body)
+	project := request.Get("project")
+	repository := gitlabRepository(project)
+	event := &Event{
+		Provider: ProviderGitLab,
+		Type:     gitlabEvents[gitlabEvent],
+		Owner:    repository["owner"].(obj)["login"].(string),
+		Repo:     repository["name"].(string),
+		FromUser: request.Get("user.username").String(),
+	}
+	payload := obj{
+		"repository": repository,
+		"sender":     obj{"login": event.FromUser},
+		"gitlab":     json.RawMessage(body),
+	}
+	attrs := request.Get("object_attributes")
+
+	switch gitlabEvent {
+	case "Push Hook", "Tag Push Hook":
+		rawRef := request.Get("ref").String()
+		after := request.Get("after").String()
+		if s.ignoreRef(rawRef) || strings.Trim(after, "0") == "" {
+			return nil, ErrIgnoredEvent
+		}
+		if strings.HasPrefix(rawRef, "refs/tags/") {
+			event.Tag = strings.TrimPrefix(rawRef, "refs/tags/")
+		} else {
+			event.Branch = strings.TrimPrefix(rawRef, "refs/heads/")
+		}
+		event.FromUser = request.Get("user_username").String()
+		event.Commit = request.Get("checkout_sha").String()
+		commits := make([]obj, 0)
+		for _, c := range request.Get("commits").Array() {
+			commits = append(commits, obj{
+				"id":      c.Get("id").String(),
+				"message": c.Get("message").String(),
+				"url":     c.Get("url").String(),
+				"author":  obj{"name": c.Get("author.name").String()},
+			})
+		}
+		payload["sender"] = obj{"login": event.FromUser}
+		payload["ref"] = rawRef
+		payload["before"] = request.Get("before").String()
+		payload["after"] = after
+		payload["forced"] = false
+		payload["commits"] = commits
+		payload["head_commit"] = obj{"id": event.Commit}
+		payload["compare"] = fmt.Sprintf("%s/-/compare/%s...%s", project.Get("web_url").String(), request.Get("before").String(), after)
+	case "Merge Request Hook":
+		source := gitlabRepository(attrs.Get("source"))
+		target := gitlabRepository(attrs.Get("target"))
+		event.Owner = source["owner"].(obj)["login"].(string)
+		event.Repo = source["name"].(string)
+		event.Branch = attrs.Get("source_branch").String()
+		event.Commit = attrs.Get("last_commit.id").String()
+		event.BaseOwner = target["owner"].(obj)["login"].(string)
+		event.BaseRepo = target["name"].(string)
+		event.BaseBranch = attrs.Get("target_branch").String()
+		merged := attrs.Get("action").String() == "merge"
+		pr := obj{
+			"number":   attrs.Get("iid").Int(),
+			"title":    attrs.Get("title").String(),
+			"body":     attrs.Get("description").String(),
+			"html_url": attrs.Get("url").String(),
+			"state":    attrs.Get("state").String(),
+			"draft":    attrs.Get("draft").Bool() || attrs.Get("work_in_progress").Bool(),
+			"merged":   merged,
+			"head": obj{
+				"ref":   event.Branch,
+				"sha":   event.Commit,
+				"label": event.Owner + ":" + event.Branch,
+				"repo":  source,
+			},
+			"base": obj{
+				"ref":  event.BaseBranch,
+				"repo": target,
+			},
+		}
+		if merged {
+			pr["merged_by"] = obj{"login": event.FromUser}
+		}
+		payload["pull_request"] = pr
+		payload["repository"] = target
+		switch attrs.Get("action").String() {
+		case "open":
+			event.Action = "opened"
+		case "close", "merge":
+			event.Action = "closed"
+		case "reopen":
+			event.Action = "reopened"
+		case "update":
+			event.Action = "edited"
+			if oldrev := attrs.Get("oldrev").String(); oldrev != "" {
+				event.Action = "synchronize"
+				payload["before"] = oldrev
+				payload["after"] = event.Commit
+			}
+		case "approved":
+			event.Type = "pull_request_review"
+			event.Action = "submitted"
+			payload["review"] = obj{"state": "approved"}
+		default:
+			event.Action = attrs.Get("action").String()
+		}
+	case "Issue Hook":
+		payload["issue"] = gitlabIssue(attrs, request.Get("labels"))
+		switch attrs.Get("action").String() {
+		case "open":
+			event.Action = "opened"
+		case "close":
+			event.Action = "closed"
+		case "reopen":
+			event.Action = "reopened"
+		case "update":
+			event.Action = "edited"
+		default:
+			event.Action = attrs.Get("action").String()
+		}
+	case "Note Hook":
+		var issue obj
+		switch attrs.Get("noteable_type").String() {
+		case "Issue":
+			issue = gitlabIssue(request.Get("issue"), request.Get("issue.labels"))
+		case "MergeRequest":
+			issue = gitlabIssue(request.Get("merge_request"), request.Get("merge_request.labels"))
+			issue["pull_request"] = obj{"html_url": request.Get("merge_request.url").String()}
+		default:
+			// commit、snippet 的评论没有对应的 github event
+			return nil, ErrIgnoredEvent
+		}
+		payload["issue"] = issue
+		payload["comment"] = obj{
+			"id":       attrs.Get("id").Int(),
+			"body":     attrs.Get("note").String(),
+			"html_url": attrs.Get("url").String(),
+		}
+		event.Action = "created"
+		if attrs.Get("action").String() == "update" {
+			event.Action = "edited"
+		}
+	case "Pipeline Hook":
+		status := attrs.Get("status").String()
+		event.Branch = attrs.Get("ref").String()
+		event.Commit = attrs.Get("sha").String()
+		url := attrs.Get("url").String()
+		if url == "" {
+			url = fmt.Sprintf("%s/-/pipelines/%d", project.Get("web_url").String(), attrs.Get("id").Int())
+		}
+		var failedJobs []string
+		for _, build := range request.Get("builds").Array() {
+			if build.Get("status").String() == "failed" {
+				failedJobs = append(failedJobs, build.Get("name").String())
+			}
+		}
+		run := obj{
+			"id":          attrs.Get("id").Int(),
+			"name":        "pipeline",
+			"head_branch": event.Branch,
+			"head_sha":    event.Commit,
+			"html_url":    url,
+			"status":      "completed",
+			"conclusion":  gitlabConclusion(status),
+			"duration":    attrs.Get("duration").Int(),
+			"failed_jobs": failedJobs,
+		}
+		switch status {
+		case "success", "failed", "canceled", "skipped":
+			event.Action = "completed"
+		case "running":
+			event.Action = "in_progress"
+			run["status"] = event.Action
+			run["conclusion"] = nil
+		default:
+			event.Action = "requested"
+			run["status"] = "queued"
+			run["conclusion"] = nil
+		}
+		payload["workflow_run"] = run
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = gjson.ParseBytes(raw)
+	return event, nil
+}
+
+// gitlabRepository 把 gitlab 的 project 转换成 github 的 repository
+func gitlabRepository(project gjson.Result) obj {
+	fullName := project.Get("path_with_namespace").String()
+	owner, name := project.Get("namespace").String(), project.Get("name").String()
+	if i := strings.LastIndex(fullName, "/"); i >= 0 {
+		owner, name = fullName[:i], fullName[i+1:]
+	}
+	return obj{
+		"name":      name,
+		"full_name": fullName,
+		"html_url":  project.Get("web_url").String(),
+		"owner":     obj{"login": owner},
+	}
+}
+
+// gitlabIssue 把 gitlab 的 issue、merge request 转换成 github 的 issue
+func gitlabIssue(issue, labels gjson.Result) obj {
+	list := make([]obj, 0)
+	for _, label := range labels.Array() {
+		list = append(list, obj{
+			"name":  label.Get("title").String(),
+			"color": strings.TrimPrefix(label.Get("color").String(), "#"),
+		})
+	}
+	return obj{
+		"id":       issue.Get("id").Int(),
+		"number":   issue.Get("iid").Int(),
+		"title":    issue.Get("title").String(),
+		"body":     issue.Get("description").String(),
+		"html_url": issue.Get("url").String(),
+		"state":    issue.Get("state").String(),
+		"labels":   list,
+	}
+}
+
+// gitlabConclusion 把 gitlab pipeline 的状态转换成 github 的 conclusion
+func gitlabConclusion(status string) string {
+	switch status {
+	case "failed":
+		return "failure"
+	case "canceled":
+		return "cancelled"
+	default:
+		return status
+	}
+}
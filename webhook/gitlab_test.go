@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const gitlabProject = `"project":{"name":"hello","namespace":"group","path_with_namespace":"group/sub/hello","web_url":"https://gitlab.com/group/sub/hello"}`
+
+// TestParseGitLabEvent 测试 gitlab payload 转换成 github 格式后使用默认模板渲染
+func TestParseGitLabEvent(t *testing.T) {
+	tpl, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	tests := []struct {
+		name       string
+		hook       string
+		body       string
+		wantType   string
+		wantAction string
+		want       string
+	}{
+		{
+			name:     "push",
+			hook:     "Push Hook",
+			body:     `{"ref":"refs/heads/main","before":"1111111aaaa","after":"2222222bbbb","checkout_sha":"2222222bbbb","user_username":"alice",` + gitlabProject + `,"commits":[{"id":"2222222bbbb","message":"fix bug\n\ndetail","url":"https://gitlab.com/c","author":{"name":"Alice"}}],"total_commits_count":1}`,
+			wantType: "push",
+			want:     "alice pushed 1 commit to group/sub/hello:main\n[2222222] fix bug - Alice\ncompare: https://gitlab.com/group/sub/hello/-/compare/1111111aaaa...2222222bbbb",
+		},
+		{
+			name:       "merge request merged",
+			hook:       "Merge Request Hook",
+			body:       `{"user":{"username":"bob"},` + gitlabProject + `,"object_attributes":{"iid":3,"title":"Add feature","description":"desc","url":"https://gitlab.com/group/sub/hello/-/merge_requests/3","state":"merged","action":"merge","source_branch":"feat","target_branch":"main","last_commit":{"id":"abcdef0123"},"source":{"name":"hello","namespace":"bob","path_with_namespace":"bob/hello"},"target":{"name":"hello","namespace":"group","path_with_namespace":"group/sub/hello"}}}`,
+			wantType:   "pull_request",
+			wantAction: "closed",
+			want:       "bob merged pull request group/sub/hello #3 (main<-bob:feat)\nTitle: Add feature\njump: https://gitlab.com/group/sub/hello/-/merge_requests/3",
+		},
+		{
+			name:       "issue opened",
+			hook:       "Issue Hook",
+			body:       `{"user":{"username":"carol"},` + gitlabProject + `,"object_attributes":{"id":9,"iid":7,"title":"Crash","description":"boom","url":"https://gitlab.com/group/sub/hello/-/issues/7","action":"open"},"labels":[{"title":"bug","color":"#ff0000"}]}`,
+			wantType:   "issues",
+			wantAction: "opened",
+			want:       "carol opened issue group/sub/hello #7 \njump: https://gitlab.com/group/sub/hello/-/issues/7 \n[bug] Title: Crash \nBody: boom",
+		},
+		{
+			name:       "merge request note",
+			hook:       "Note Hook",
+			body:       `{"user":{"username":"dave"},` + gitlabProject + `,"object_attributes":{"id":11,"note":"LGTM","noteable_type":"MergeRequest","url":"https://gitlab.com/group/sub/hello/-/merge_requests/3#note_11"},"merge_request":{"iid":3,"title":"Add feature","url":"https://gitlab.com/group/sub/hello/-/merge_requests/3"}}`,
+			wantType:   "issue_comment",
+			wantAction: "created",
+		},
+		{
+			name:       "pipeline failed",
+			hook:       "Pipeline Hook",
+			body:       `{"user":{"username":"erin"},` + gitlabProject + `,"object_attributes":{"id":42,"ref":"main","sha":"0123456789","status":"failed","duration":192},"builds":[{"name":"lint","status":"failed"},{"name":"build","status":"success"}]}`,
+			wantType:   "workflow_run",
+			wantAction: "completed",
+			want:       "CI failed on main @0123456 (pipeline / lint) — 3m12s\nrepo: group/sub/hello\njump: https://gitlab.com/group/sub/hello/-/pipelines/42",
+		},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := s.parseGitLabEvent(tt.hook, []byte(tt.body))
+			if err != nil {
+				t.Fatalf("parseGitLabEvent err %v", err)
+			}
+			if event.Provider != ProviderGitLab || event.Type != tt.wantType || event.Action != tt.wantAction {
+				t.Fatalf("event = %s %s.%s, want gitlab %s.%s", event.Provider, event.Type, event.Action, tt.wantType, tt.wantAction)
+			}
+			if event.FullName() != "group/sub/hello" {
+				t.Errorf("FullName() = %s, want group/sub/hello", event.FullName())
+			}
+			if !event.Payload.Get("gitlab").IsObject() {
+				t.Errorf("raw gitlab payload not kept")
+			}
+			got, err := tpl.Render(&TemplateData{Event: event, Payload: event.Payload, MaxCommits: 5, CI: NewCIStatus(event)})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+			if got == "" {
+				t.Errorf("Render() is empty")
+			}
+		})
+	}
+
+	if _, err := s.parseGitLabEvent("Note Hook", []byte(`{"object_attributes":{"noteable_type":"Snippet"}}`)); err != ErrIgnoredEvent {
+		t.Errorf("snippet note err = %v, want %v", err, ErrIgnoredEvent)
+	}
+}
+
+// TestServeGitLab 测试 gitlab 路径的 token 校验
+func TestServeGitLab(t *testing.T) {
+	s := NewServer()
+	body := `{"user":{"username":"carol"},` + gitlabProject + `,"object_attributes":{"iid":7,"action":"open"}}`
+	// 没有配置时不接收gitlab的投递，只配置了路径时拒绝所有投递
+	for _, path := range []string{"", DefaultGitLabPath} {
+		s.GitLabPath = path
+		req := httptest.NewRequest(http.MethodPost, DefaultGitLabPath, strings.NewReader(body))
+		req.Header.Set("X-Gitlab-Event", "Issue Hook")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			t.Errorf("path %q without token code = %d, want rejected", path, w.Code)
+		}
+	}
+	s.GitLabTokens = []string{"old", "new"}
+	tests := []struct {
+		token    string
+		wantCode int
+	}{
+		{"new", http.StatusOK},
+		{"old", http.StatusOK},
+		{"bad", http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, s.GitLabPath, strings.NewReader(body))
+		req.Header.Set("X-Gitlab-Event", "Issue Hook")
+		req.Header.Set("X-Gitlab-Token", tt.token)
+		req.Header.Set("X-Gitlab-Event-UUID", "uuid-1")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("token %q code = %d, want %d", tt.token, w.Code, tt.wantCode)
+		}
+		if tt.wantCode == http.StatusOK {
+			event := <-s.Events
+			if event.DeliveryID != "uuid-1" || event.Type != "issues" {
+				t.Errorf("event = %s %s, want uuid-1 issues", event.DeliveryID, event.Type)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tebeka/selenium"
+)
+
+// ErrPoolClosed 会话池已经关闭
+var ErrPoolClosed = errors.New("webdriver session pool closed")
+
+// minReapInterval 清理空闲会话的最小间隔，idleTimeout 很小时避免 ticker 间隔为0
+const minReapInterval = time.Second
+
+// session 池中的一个 WebDriver 会话
+type session struct {
+	wd       selenium.WebDriver
+	lastUsed time.Time
+}
+
+// SessionPool 可复用的 WebDriver 会话池。
+// 每次截图都新建远程会话要好几秒，并发时还会压垮 selenium grid，
+// 这里最多同时使用 size 个会话，用完放回池中，空闲超过 idleTimeout 的会话会被关闭，
+// 取出时检查会话是否还活着，已经失效的会话会被丢弃并重新创建
+type SessionPool struct {
+	mu          sync.Mutex
+	newSession  func() (selenium.WebDriver, error)
+	reset       func(wd selenium.WebDriver) error // 放回池中前重置会话，如窗口大小，返回错误时丢弃该会话
+	idle        []*session                        // 空闲会话，后进先出
+	slots       chan struct{}                     // 限制同时使用的会话数
+	idleTimeout time.Duration
+	closed      bool
+	done        chan struct{}
+	now         func() time.Time
+}
+
+// NewSessionPool 初始化会话池，size 最多同时使用的会话数，idleTimeout 空闲会话的过期时间，<=0 表示不过期
+func NewSessionPool(newSession func() (selenium.WebDriver, error), reset func(wd selenium.WebDriver) error, size int, idleTimeout time.Duration) *SessionPool {
+	if size <= 0 {
+		size = 1
+	}
+	p := &SessionPool{
+		newSession:  newSession,
+		reset:       reset,
+		slots:       make(chan struct{}, size),
+		idleTimeout: idleTimeout,
+		done:        make(chan struct{}),
+		now:         time.Now,
+	}
+	if idleTimeout > 0 {
+		go p.reapLoop()
+	}
+	return p
+}
+
+// Get 取出一个可用的会话，池中没有空闲会话时新建，达到最大并发数时等待，直到 ctx 结束。
+// 用完后必须调用 Put 放回
+func (p *SessionPool) Get(ctx context.Context) (selenium.WebDriver, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for {
+		s, err := p.popIdle()
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		if s == nil {
+			break
+		}
+		// 健康检查，grid 重启、会话超时被回收后会话就失效了
+		if _, err := s.wd.CurrentWindowHandle(); err != nil {
+			log.Warnf("drop dead webdriver session: %v", err)
+			_ = s.wd.Quit()
+			continue
+		}
+		return s.wd, nil
+	}
+	wd, err := p.newSession()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return wd, nil
+}
+
+// popIdle 取出最近使用的空闲会话，过期的直接关闭，没有时返回nil
+func (p *SessionPool) popIdle() (*session, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	var (
+		s       *session
+		expired []*session
+	)
+	for len(p.idle) > 0 && s == nil {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.expired(last) {
+			expired = append(expired, last)
+		} else {
+			s = last
+		}
+	}
+	p.mu.Unlock()
+	for _, e := range expired {
+		_ = e.wd.Quit()
+	}
+	return s, nil
+}
+
+// Put 把会话放回池中，重置失败或池已经关闭时关闭该会话
+func (p *SessionPool) Put(wd selenium.WebDriver) {
+	defer func() { <-p.slots }()
+	if wd == nil {
+		return
+	}
+	if p.reset != nil {
+		if err := p.reset(wd); err != nil {
+			log.Warnf("drop webdriver session, reset err:%v", err)
+			_ = wd.Quit()
+			return
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		_ = wd.Quit()
+		return
+	}
+	p.idle = append(p.idle, &session{wd: wd, lastUsed: p.now()})
+}
+
+// Idle 空闲会话数
+func (p *SessionPool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// expired 会话是否空闲太久
+func (p *SessionPool) expired(s *session) bool {
+	return p.idleTimeout > 0 && p.now().Sub(s.lastUsed) > p.idleTimeout
+}
+
+// reap 关闭空闲太久的会话
+func (p *SessionPool) reap() {
+	p.mu.Lock()
+	var alive, expired []*session
+	for _, s := range p.idle {
+		if p.expired(s) {
+			expired = append(expired, s)
+		} else {
+			alive = append(alive, s)
+		}
+	}
+	p.idle = alive
+	p.mu.Unlock()
+	for _, s := range expired {
+		_ = s.wd.Quit()
+	}
+}
+
+// reapLoop 定时关闭空闲太久的会话，避免一直占用 grid 的资源
+func (p *SessionPool) reapLoop() {
+	interval := p.idleTimeout / 2
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.reap()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Close 关闭所有空闲会话，之后放回的会话也会被关闭
+func (p *SessionPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	close(p.done)
+	for _, s := range idle {
+		_ = s.wd.Quit()
+	}
+}
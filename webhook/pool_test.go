@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tebeka/selenium"
+)
+
+// fakeWebDriver 只实现会话池用到的方法
+type fakeWebDriver struct {
+	selenium.WebDriver
+	dead bool
+	quit bool
+}
+
+func (f *fakeWebDriver) CurrentWindowHandle() (string, error) {
+	if f.dead {
+		return "", errors.New("invalid session id")
+	}
+	return "window", nil
+}
+
+func (f *fakeWebDriver) Quit() error {
+	f.quit = true
+	return nil
+}
+
+// TestSessionPool 测试会话复用、健康检查、空闲过期和最大并发
+func TestSessionPool(t *testing.T) {
+	var created int32
+	newSession := func() (selenium.WebDriver, error) {
+		atomic.AddInt32(&created, 1)
+		return &fakeWebDriver{}, nil
+	}
+	p := NewSessionPool(newSession, nil, 2, 0)
+	defer p.Close()
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	p.idleTimeout = time.Minute
+
+	ctx := context.Background()
+	wd1, err := p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get err %v", err)
+	}
+	p.Put(wd1)
+	wd2, _ := p.Get(ctx)
+	if wd2 != wd1 || created != 1 {
+		t.Errorf("session not reused, created %d", created)
+	}
+
+	// 达到最大并发数时等待
+	wd3, _ := p.Get(ctx)
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() over size err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 失效的会话被丢弃并重新创建
+	p.Put(wd2)
+	p.Put(wd3)
+	wd3.(*fakeWebDriver).dead = true
+	wd4, _ := p.Get(ctx)
+	if !wd3.(*fakeWebDriver).quit || wd4 != wd2 {
+		t.Fatalf("dead session should be dropped, got %v", wd4)
+	}
+	p.Put(wd4)
+
+	// 空闲过期
+	now = now.Add(2 * time.Minute)
+	p.reap()
+	if p.Idle() != 0 || !wd2.(*fakeWebDriver).quit {
+		t.Errorf("idle session not reaped, idle %d", p.Idle())
+	}
+
+	p.Close()
+	if _, err := p.Get(ctx); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Get() after Close err = %v, want %v", err, ErrPoolClosed)
+	}
+
+	// 很小的过期时间不能让清理的 ticker 间隔为0
+	NewSessionPool(newSession, nil, 1, time.Nanosecond).Close()
+}
@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/scjtqs2/bot_app_github/config"
+)
+
+// 截图后端
+const (
+	RendererSeleniumChrome  = "selenium-chrome"  // 通过 selenium 的 chrome 截图
+	RendererSeleniumFirefox = "selenium-firefox" // 通过 selenium 的 firefox 截图
+	RendererChromedp        = "chromedp"         // 通过 Chrome DevTools Protocol 直接控制 headless chrome 截图
+)
+
+// ErrUnknownRenderer 不支持的截图后端
+var ErrUnknownRenderer = errors.New("unknown screenshot renderer")
+
+// ErrPageUnavailable 打开的是 github 的登录页或404页，如没有登录时访问私有仓库
+var ErrPageUnavailable = errors.New("page not found or login required")
+
+// cookieURL 登录 cookie 所属的站点
+const cookieURL = "https://github.com/"
+
+// unavailableScript 判断当前页面是否为 github 的登录页或404页的js
+const unavailableScript = `location.pathname.startsWith("/login") || location.pathname.startsWith("/session") || document.title.startsWith("Page not found") || !!document.querySelector('form[action="/session"]')`
+
+// RenderRequest 一次页面截图的请求。
+// 选择器以 / 开头时按 XPath 查找，否则按 CSS 选择器查找
+type RenderRequest struct {
+	URL      string        // 页面地址
+	Selector string        // 要截取的元素
+	Fallback string        // Selector 找不到时退回截取的元素，为空表示不退回
+	WaitFor  string        // 截图前等待出现的元素，为空时等待 Selector
+	SizeFrom string        // 按该元素的大小调整窗口，为空时按 Selector
+	Remove   []string      // 截图前从页面上删除的元素，如侧边栏
+	Delay    time.Duration // 调整窗口后等待页面稳定的时间
+	Version  string        // 页面内容的版本，如 payload 中的 updated_at，和 URL 一起作为截图缓存的key，为空时不缓存
+	Variant  string        // 截图的外观，如视口大小、配色，外观不同的截图分开缓存
+}
+
+// cacheKey 截图缓存的key，不能缓存时为空
+func (r *RenderRequest) cacheKey() string {
+	if r.Version == "" {
+		return ""
+	}
+	return CacheKey(r.URL, r.Selector, r.Version, r.Variant)
+}
+
+// waitFor 截图前等待出现的元素
+func (r *RenderRequest) waitFor() string {
+	if r.WaitFor != "" {
+		return r.WaitFor
+	}
+	return r.Selector
+}
+
+// sizeFrom 决定窗口大小的元素
+func (r *RenderRequest) sizeFrom() string {
+	if r.SizeFrom != "" {
+		return r.SizeFrom
+	}
+	return r.Selector
+}
+
+// isXPath 选择器是否为 XPath
+func isXPath(selector string) bool {
+	return strings.HasPrefix(selector, "/") || strings.HasPrefix(selector, "(")
+}
+
+// Renderer 截图后端，打开页面并截取元素，返回 png 图片
+type Renderer interface {
+	Render(ctx context.Context, req *RenderRequest) ([]byte, error)
+	Close() error
+}
+
+// RendererConfig 截图后端的配置
+type RendererConfig struct {
+	Type         string         // 截图后端，为空表示不截图
+	Addr         string         // selenium 的 api 地址，或 chrome 的远程调试地址，chromedp 为空时在本机启动 chrome
+	PoolSize     int            // 最多同时打开的会话或标签页数
+	IdleTimeout  time.Duration  // selenium 空闲会话的过期时间
+	ChromeExec   string         // chromedp 在本机启动 chrome 时的可执行文件，为空时自动查找
+	WindowWidth  int            // 默认窗口宽度
+	WindowHeight int            // 默认窗口高度
+	Scale        float64        // 设备像素比，大于1时截图更清晰
+	Theme        string         // 页面的配色：light、dark，为空时使用浏览器默认
+	Cookies      []*http.Cookie // 注入浏览器的 github 登录 cookie，用于截取私有仓库
+	ProfileDir   string         // 浏览器的用户数据目录，保存了登录状态，selenium 时为浏览器所在机器上的路径
+}
+
+// ParseCookies 解析 "user_session=xxx; logged_in=yes" 形式的 cookie，可以直接从浏览器的请求头复制
+func ParseCookies(s string) []*http.Cookie {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return (&http.Request{Header: http.Header{"Cookie": []string{s}}}).Cookies()
+}
+
+// variant 截图的外观，用于区分缓存
+func (c RendererConfig) variant() string {
+	return fmt.Sprintf("%dx%d@%g %s", c.WindowWidth, c.WindowHeight, c.Scale, c.Theme)
+}
+
+// 页面配色
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+)
+
+// NewRendererConfig 按配置生成截图后端的配置。
+// 没有配置 renderer 时兼容旧的 SELENIUM_CHROME_ENABLE、SELENIUM_FIREFOX_ENABLE 开关
+func NewRendererConfig(shot config.Screenshot) RendererConfig {
+	cfg := RendererConfig{
+		Type:         strings.ToLower(strings.TrimSpace(shot.Renderer)),
+		PoolSize:     shot.Selenium.PoolSize,
+		IdleTimeout:  shot.Selenium.IdleTimeout,
+		ChromeExec:   shot.Chromedp.Exec,
+		WindowWidth:  shot.Width,
+		WindowHeight: shot.Height,
+		Scale:        shot.Scale,
+		Theme:        strings.ToLower(strings.TrimSpace(shot.Theme)),
+		Cookies:      ParseCookies(shot.Cookie),
+		ProfileDir:   shot.ProfileDir,
+	}
+	if cfg.Type == "" {
+		// 两个都开启时以前是 firefox 生效
+		switch {
+		case shot.Selenium.FirefoxEnable:
+			cfg.Type = RendererSeleniumFirefox
+		case shot.Selenium.ChromeEnable:
+			cfg.Type = RendererSeleniumChrome
+		}
+	}
+	switch cfg.Type {
+	case RendererSeleniumChrome:
+		cfg.Addr = shot.Selenium.ChromeAddr
+	case RendererSeleniumFirefox:
+		cfg.Addr = shot.Selenium.FirefoxAddr
+	case RendererChromedp:
+		cfg.Addr = shot.Chromedp.Addr
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 2
+	}
+	// 浏览器会锁定用户数据目录，selenium 的多个会话不能共用
+	if cfg.ProfileDir != "" && cfg.PoolSize > 1 && (cfg.Type == RendererSeleniumChrome || cfg.Type == RendererSeleniumFirefox) {
+		log.Warnf("SCREENSHOT_PROFILE_DIR 只能被一个浏览器使用，SELENIUM_POOL_SIZE 由 %d 改为 1", cfg.PoolSize)
+		cfg.PoolSize = 1
+	}
+	if cfg.WindowWidth <= 0 || cfg.WindowHeight <= 0 {
+		cfg.WindowWidth, cfg.WindowHeight = 600, 812
+	}
+	if cfg.Scale <= 0 {
+		cfg.Scale = 1
+	}
+	if cfg.Theme != ThemeLight && cfg.Theme != ThemeDark {
+		cfg.Theme = ""
+	}
+	return cfg
+}
+
+// NewRenderer 按配置初始化截图后端，没有配置时返回nil，不支持的类型返回 ErrUnknownRenderer
+func NewRenderer(cfg RendererConfig) (Renderer, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case RendererSeleniumChrome, RendererSeleniumFirefox:
+		return NewSeleniumRenderer(cfg), nil
+	case RendererChromedp:
+		return NewChromedpRenderer(cfg), nil
+	}
+	return nil, unknownRendererError(cfg.Type)
+}
+
+// unknownRendererError 不支持的截图后端，错误信息中带上配置的类型
+type unknownRendererError string
+
+func (e unknownRendererError) Error() string {
+	return ErrUnknownRenderer.Error() + ": " + string(e)
+}
+
+// Is 支持 errors.Is(err, ErrUnknownRenderer)
+func (e unknownRendererError) Is(target error) bool {
+	return target == ErrUnknownRenderer
+}
+
+// SplitImage 把高度超过 maxHeight 的 png 图片从上到下切成多张，maxHeight<=0 时不切
+func SplitImage(pic []byte, maxHeight int) ([][]byte, error) {
+	if maxHeight <= 0 {
+		return [][]byte{pic}, nil
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(pic))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Height <= maxHeight {
+		return [][]byte{pic}, nil
+	}
+	img, err := png.Decode(bytes.NewReader(pic))
+	if err != nil {
+		return nil, err
+	}
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		errmsg := fmt.Sprintf("unsupported image type %T", img)
+		return nil, errors.New(errmsg)
+	}
+	b := img.Bounds()
+	var parts [][]byte
+	for y := b.Min.Y; y < b.Max.Y; y += maxHeight {
+		bottom := y + maxHeight
+		if bottom > b.Max.Y {
+			bottom = b.Max.Y
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, sub.SubImage(image.Rect(b.Min.X, y, b.Max.X, bottom))); err != nil {
+			return nil, err
+		}
+		parts = append(parts, buf.Bytes())
+	}
+	return parts, nil
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scjtqs2/bot_app_github/config"
+)
+
+// TestNewRendererConfig 测试截图后端的选择和旧开关的兼容
+func TestNewRendererConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantType string
+		wantAddr string
+	}{
+		{"none", nil, "", ""},
+		{"legacy chrome", map[string]string{"SELENIUM_CHROME_ENABLE": "true", "SELENIUM_CHROME_ADDR": "http://chrome:4444/wd/hub"}, RendererSeleniumChrome, "http://chrome:4444/wd/hub"},
+		{"legacy firefox wins", map[string]string{"SELENIUM_CHROME_ENABLE": "true", "SELENIUM_FIREFOX_ENABLE": "true", "SELENIUM_FIREFOX_ADDR": "http://firefox:4444"}, RendererSeleniumFirefox, "http://firefox:4444"},
+		{"chromedp", map[string]string{"SCREENSHOT_RENDERER": " ChromeDP ", "SELENIUM_CHROME_ENABLE": "true", "CHROMEDP_ADDR": "ws://chrome:9222"}, RendererChromedp, "ws://chrome:9222"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"SCREENSHOT_RENDERER", "SELENIUM_CHROME_ENABLE", "SELENIUM_CHROME_ADDR", "SELENIUM_FIREFOX_ENABLE", "SELENIUM_FIREFOX_ADDR", "CHROMEDP_ADDR"} {
+				t.Setenv(key, tt.env[key])
+			}
+			conf, err := config.Load("")
+			if err != nil {
+				t.Fatalf("config.Load err %v", err)
+			}
+			cfg := NewRendererConfig(conf.Screenshot)
+			if cfg.Type != tt.wantType || cfg.Addr != tt.wantAddr {
+				t.Errorf("NewRendererConfig() = %s %s, want %s %s", cfg.Type, cfg.Addr, tt.wantType, tt.wantAddr)
+			}
+			r, err := NewRenderer(cfg)
+			if err != nil {
+				t.Fatalf("NewRenderer err %v", err)
+			}
+			if (r == nil) != (tt.wantType == "") {
+				t.Errorf("NewRenderer() = %v", r)
+			}
+			if r != nil {
+				_ = r.Close()
+			}
+		})
+	}
+	t.Setenv("SCREENSHOT_WIDTH", "1024")
+	t.Setenv("SCREENSHOT_SCALE", "2")
+	t.Setenv("SCREENSHOT_THEME", "Dark")
+	conf, err := config.Load("")
+	if err != nil {
+		t.Fatalf("config.Load err %v", err)
+	}
+	if cfg := NewRendererConfig(conf.Screenshot); cfg.WindowWidth != 1024 || cfg.WindowHeight != 812 || cfg.Scale != 2 || cfg.Theme != ThemeDark {
+		t.Errorf("NewRendererConfig() = %+v", cfg)
+	}
+	if cfg := NewRendererConfig(config.Screenshot{Theme: "solarized"}); cfg.Theme != "" || cfg.WindowWidth != 600 || cfg.PoolSize != 2 || cfg.Scale != 1 {
+		t.Errorf("NewRendererConfig() = %+v", cfg)
+	}
+	profile := config.Screenshot{Renderer: RendererSeleniumChrome, ProfileDir: "/profile", Selenium: config.Selenium{PoolSize: 4}}
+	if cfg := NewRendererConfig(profile); cfg.PoolSize != 1 {
+		t.Errorf("NewRendererConfig() with profile dir pool size = %d, want 1", cfg.PoolSize)
+	}
+	if _, err := NewRenderer(RendererConfig{Type: "phantomjs"}); !errors.Is(err, ErrUnknownRenderer) || !strings.Contains(err.Error(), "phantomjs") {
+		t.Errorf("NewRenderer(phantomjs) err = %v, want %v", err, ErrUnknownRenderer)
+	}
+}
+
+// TestRenderRequest 测试按event生成的截图请求
+func TestRenderRequest(t *testing.T) {
+	s := NewServer()
+	g := &GHook{RendererType: RendererSeleniumFirefox}
+	event, err := s.parseEvent("pull_request_review_comment", []byte(`{"action":"created","comment":{"id":12,"html_url":"https://github.com/octocat/hello/pull/1#discussion_r12"},"repository":{"full_name":"octocat/hello"}}`))
+	if err != nil {
+		t.Fatalf("parseEvent err %v", err)
+	}
+	req := g.renderRequest(event)
+	if req == nil || !isXPath(req.Selector) || isXPath(req.Fallback) || req.Fallback != "#discussion_r12" || req.sizeFrom() != "#js-repo-pjax-container" {
+		t.Errorf("renderRequest() = %+v", req)
+	}
+	event, _ = s.parseEvent("pull_request", []byte(`{"action":"opened","pull_request":{"html_url":"https://github.com/octocat/hello/pull/1"},"repository":{"full_name":"octocat/hello"}}`))
+	if req := g.renderRequest(event); req != nil {
+		t.Errorf("firefox should not screenshot pull request, got %+v", req)
+	}
+	g.RendererType = RendererChromedp
+	if req := g.renderRequest(event); req == nil || req.waitFor() != "#js-repo-pjax-container" || len(req.Remove) != 2 {
+		t.Errorf("renderRequest() = %+v", req)
+	}
+}
+
+// TestSelectorRules 测试配置的截图规则覆盖内置规则
+func TestSelectorRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	_ = os.WriteFile(file, []byte(`{"issue_comment":{"selector":"#issuecomment-{id}","delay":"1s"},"issue":{"remove":[]}}`), 0o644)
+	rules, err := LoadSelectorRules(file)
+	if err != nil {
+		t.Fatalf("LoadSelectorRules err %v", err)
+	}
+	g := &GHook{SelectorRules: rules, renderVariant: "600x812@1 dark"}
+	req := g.pageRequest(PageIssueComment, "https://github.com/octocat/hello/issues/1#issuecomment-7", "7", "2022-02-10T10:00:00Z")
+	if req.Selector != "#issuecomment-7" || req.sizeFrom() != "#js-repo-pjax-container" || req.Delay != time.Second {
+		t.Errorf("pageRequest(issue_comment) = %+v", req)
+	}
+	if req := g.pageRequest(PageIssue, "https://github.com/octocat/hello/issues/1", "", ""); req.Selector != "#js-repo-pjax-container" || len(req.Remove) != 0 {
+		t.Errorf("pageRequest(issue) = %+v", req)
+	}
+	req = g.pageRequest(PageReviewComment, "https://github.com/octocat/hello/pull/1#discussion_r9", "9", "v1")
+	if req.Fallback != "#discussion_r9" {
+		t.Errorf("pageRequest(review_comment) = %+v", req)
+	}
+	key := req.cacheKey()
+	g.renderVariant = "600x812@1 light"
+	if g.pageRequest(PageReviewComment, req.URL, "9", "v1").cacheKey() == key {
+		t.Errorf("cacheKey() should change with theme")
+	}
+
+	for _, text := range []string{`{"issues":{"selector":"#issue"}}`, `{"release":{"delay":"5"}}`} {
+		_ = os.WriteFile(file, []byte(text), 0o644)
+		if _, err := LoadSelectorRules(file); err == nil {
+			t.Errorf("LoadSelectorRules(%s) should fail", text)
+		}
+	}
+}
+
+// TestSplitImage 测试按最大高度切分图片
+func TestSplitImage(t *testing.T) {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 25)))
+	pic := buf.Bytes()
+	if parts, err := SplitImage(pic, 0); err != nil || len(parts) != 1 {
+		t.Errorf("SplitImage(0) = %d parts, %v", len(parts), err)
+	}
+	parts, err := SplitImage(pic, 10)
+	if err != nil || len(parts) != 3 {
+		t.Fatalf("SplitImage(10) = %d parts, %v", len(parts), err)
+	}
+	for i, want := range []int{10, 10, 5} {
+		img, err := png.Decode(bytes.NewReader(parts[i]))
+		if err != nil || img.Bounds().Dy() != want || img.Bounds().Dx() != 10 {
+			t.Errorf("part %d = %v %v, want height %d", i, img.Bounds(), err, want)
+		}
+	}
+}
+
+// TestParseCookies 测试解析从浏览器复制的 cookie
+func TestParseCookies(t *testing.T) {
+	if cookies := ParseCookies(" "); cookies != nil {
+		t.Errorf("ParseCookies(empty) = %v", cookies)
+	}
+	cookies := NewRendererConfig(config.Screenshot{Cookie: "user_session=abc; __Host-user_session_same_site=abc; logged_in=yes"}).Cookies
+	if len(cookies) != 3 || cookies[0].Name != "user_session" || cookies[0].Value != "abc" || cookies[2].Value != "yes" {
+		t.Errorf("ParseCookies() = %v", cookies)
+	}
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Route 推送路由规则，把仓库和event类型映射到需要推送的qq和群
+type Route struct {
+	Repos  []string `json:"repos"`  // owner/repo，支持glob，如 scjtqs2/* 、*/*
+	Orgs   []string `json:"orgs"`   // 组织，支持glob，为空表示全部，只有github app、组织的webhook带有组织信息
+	Events []string `json:"events"` // event类型，如 issues、issues.opened、pull_request.*，为空表示全部
+	QQ     []int64  `json:"qq"`     // 接收推送的qq
+	Groups []int64  `json:"groups"` // 接收推送的群
+}
+
+// match 判断 event 是否命中该规则
+func (r *Route) match(fullName string, event *Event) bool {
+	return matchRepo(r.Repos, fullName) && matchOrg(r.Orgs, event.Organization) && matchEvent(r.Events, event.Type, event.Action)
+}
+
+// matchRepo 判断仓库是否命中glob规则，规则为空时匹配全部
+func matchRepo(patterns []string, fullName string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	fullName = strings.ToLower(fullName)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), fullName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrg 判断组织是否命中glob规则，规则为空时匹配全部，event 没有组织时不匹配非空的规则
+func matchOrg(patterns []string, org string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	if org == "" {
+		return false
+	}
+	org = strings.ToLower(org)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), org); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEvent 判断event类型和action是否命中规则。
+// 规则不带 "." 时只匹配类型，带 "." 时按 glob 匹配 "类型.action"
+func matchEvent(patterns []string, eventType, action string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" {
+			return true
+		}
+		if !strings.Contains(p, ".") {
+			if p == eventType {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, eventType+"."+action); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchBranch 判断分支是否命中glob规则，规则为空时匹配全部
+func matchBranch(patterns []string, branch string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Router 推送路由表
+type Router struct {
+	mu     sync.RWMutex
+	routes []Route
+	subs   *Subscriptions
+}
+
+// NewRouter 初始化路由表
+func NewRouter(routes ...Route) *Router {
+	return &Router{routes: routes}
+}
+
+// Add 追加路由规则
+func (r *Router) Add(routes ...Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, routes...)
+}
+
+// Set 替换路由规则，用于热加载，不影响群订阅
+func (r *Router) Set(routes ...Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = routes
+}
+
+// SetSubscriptions 设置群订阅列表，订阅会作为额外的路由规则参与匹配
+func (r *Router) SetSubscriptions(subs *Subscriptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs = subs
+}
+
+// Routes 返回当前的路由规则，包含群订阅
+func (r *Router) Routes() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	routes := append([]Route(nil), r.routes...)
+	if r.subs != nil {
+		routes = append(routes, r.subs.Routes()...)
+	}
+	return routes
+}
+
+// Match 返回event需要推送的qq和群，已去重
+func (r *Router) Match(event *Event) (qq, groups []int64) {
+	routes := r.Routes()
+	fullName := event.FullName()
+	seenQQ := make(map[int64]bool)
+	seenGroup := make(map[int64]bool)
+	for i := range routes {
+		if !routes[i].match(fullName, event) {
+			continue
+		}
+		for _, id := range routes[i].QQ {
+			if id != 0 && !seenQQ[id] {
+				seenQQ[id] = true
+				qq = append(qq, id)
+			}
+		}
+		for _, id := range routes[i].Groups {
+			if id != 0 && !seenGroup[id] {
+				seenGroup[id] = true
+				groups = append(groups, id)
+			}
+		}
+	}
+	return qq, groups
+}
+
+// LoadRoutes 从json文件加载路由规则
+func LoadRoutes(file string) ([]Route, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	if err := json.Unmarshal(raw, &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestRouterMatch 测试路由匹配
+func TestRouterMatch(t *testing.T) {
+	router := NewRouter(
+		Route{Repos: []string{"scjtqs2/*"}, QQ: []int64{1}},
+		Route{Repos: []string{"scjtqs2/bot_app_github"}, Events: []string{"issues.opened", "pull_request"}, Groups: []int64{100}},
+		Route{Repos: []string{"*/*"}, Events: []string{"release"}, QQ: []int64{1, 2}, Groups: []int64{200}},
+		Route{Events: []string{"pull_request.clos*"}, Groups: []int64{300}},
+	)
+	tests := []struct {
+		name       string
+		event      Event
+		wantQQ     []int64
+		wantGroups []int64
+	}{
+		{
+			name:   "owner glob",
+			event:  Event{Type: "star", Action: "created", Owner: "scjtqs2", Repo: "bot_adapter"},
+			wantQQ: []int64{1},
+		},
+		{
+			name:       "event with action",
+			event:      Event{Type: "issues", Action: "opened", Owner: "scjtqs2", Repo: "bot_app_github"},
+			wantQQ:     []int64{1},
+			wantGroups: []int64{100},
+		},
+		{
+			name:   "event with other action",
+			event:  Event{Type: "issues", Action: "closed", Owner: "scjtqs2", Repo: "bot_app_github"},
+			wantQQ: []int64{1},
+		},
+		{
+			name:       "dedupe targets",
+			event:      Event{Type: "release", Action: "published", Owner: "scjtqs2", Repo: "bot_adapter"},
+			wantQQ:     []int64{1, 2},
+			wantGroups: []int64{200},
+		},
+		{
+			name:       "pull request uses base repository",
+			event:      Event{Type: "pull_request", Action: "closed", Owner: "someone", Repo: "bot_app_github", Payload: gjson.Parse(`{"repository":{"full_name":"scjtqs2/bot_app_github"}}`)},
+			wantQQ:     []int64{1},
+			wantGroups: []int64{100, 300},
+		},
+		{
+			name:  "no match",
+			event: Event{Type: "fork", Owner: "other", Repo: "repo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qq, groups := router.Match(&tt.event)
+			if !reflect.DeepEqual(qq, tt.wantQQ) {
+				t.Errorf("Match() qq = %v, want %v", qq, tt.wantQQ)
+			}
+			if !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("Match() groups = %v, want %v", groups, tt.wantGroups)
+			}
+		})
+	}
+}
+
+// TestRouterMatchOrg 测试github app、组织webhook按组织匹配路由
+func TestRouterMatchOrg(t *testing.T) {
+	router := NewRouter(
+		Route{Orgs: []string{"Acme*"}, Groups: []int64{100}},
+		Route{Orgs: []string{"acme"}, Events: []string{"installation_repositories"}, QQ: []int64{1}},
+	)
+	s := NewServer()
+	tests := []struct {
+		name             string
+		eventType        string
+		payload          string
+		wantOrg          string
+		wantInstallation int64
+		wantQQ           []int64
+		wantGroups       []int64
+	}{
+		{
+			name:             "org repository",
+			eventType:        "star",
+			payload:          `{"action":"created","repository":{"name":"api","full_name":"acme/api","owner":{"login":"acme"}},"organization":{"login":"acme"},"installation":{"id":7},"sender":{"login":"alice"}}`,
+			wantOrg:          "acme",
+			wantInstallation: 7,
+			wantGroups:       []int64{100},
+		},
+		{
+			name:             "installation repositories",
+			eventType:        "installation_repositories",
+			payload:          `{"action":"added","installation":{"id":7,"app_slug":"bot","account":{"login":"acme","type":"Organization"}},"repositories_added":[{"full_name":"acme/api"},{"full_name":"acme/web"}],"sender":{"login":"alice"}}`,
+			wantOrg:          "acme",
+			wantInstallation: 7,
+			wantQQ:           []int64{1},
+			wantGroups:       []int64{100},
+		},
+		{
+			name:      "user repository",
+			eventType: "star",
+			payload:   `{"action":"created","repository":{"name":"acme","full_name":"alice/acme","owner":{"login":"alice"}},"sender":{"login":"alice"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := s.parseEvent(tt.eventType, []byte(tt.payload))
+			if err != nil {
+				t.Fatalf("parseEvent err %v", err)
+			}
+			if event.Organization != tt.wantOrg || event.InstallationID != tt.wantInstallation {
+				t.Errorf("event org %q installation %d, want %q %d", event.Organization, event.InstallationID, tt.wantOrg, tt.wantInstallation)
+			}
+			qq, groups := router.Match(event)
+			if !reflect.DeepEqual(qq, tt.wantQQ) || !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("Match() = %v %v, want %v %v", qq, groups, tt.wantQQ, tt.wantGroups)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 截图的页面类型，用于按页面配置截图规则
+const (
+	PageIssue         = "issue"          // issue 页面
+	PagePullRequest   = "pull_request"   // pull request 页面
+	PageIssueComment  = "issue_comment"  // issue、pull request 中的评论
+	PageReviewComment = "review_comment" // pull request 中代码的 review comment
+	PageRelease       = "release"        // release 发布页
+)
+
+// SelectorRule 一类页面的截图规则，github 改版后可以通过配置文件调整。
+// 字段中的 {id} 会替换成评论的id，为空的字段使用内置的规则
+type SelectorRule struct {
+	Selector string   `json:"selector"`  // 要截取的元素，以 / 或 ( 开头时按 XPath 查找，否则按 CSS 选择器查找
+	Fallback string   `json:"fallback"`  // Selector 找不到时退回截取的元素
+	WaitFor  string   `json:"wait_for"`  // 截图前等待出现的元素
+	SizeFrom string   `json:"size_from"` // 按该元素的大小调整窗口
+	Remove   []string `json:"remove"`    // 截图前从页面上删除的元素，填 [] 表示不删除
+	Delay    string   `json:"delay"`     // 调整窗口后等待页面稳定的时间，如 5s
+}
+
+// github 页面的元素
+const (
+	repoContainer = "#js-repo-pjax-container"
+	sidebar       = "#partial-discussion-sidebar"
+	signBar       = ".discussion-timeline-actions"
+)
+
+// defaultSelectorRules 内置的截图规则
+var defaultSelectorRules = map[string]SelectorRule{
+	PageIssue: {
+		Selector: repoContainer,
+		Remove:   []string{sidebar, signBar},
+	},
+	PagePullRequest: {
+		Selector: repoContainer,
+		Remove:   []string{sidebar, signBar},
+	},
+	PageIssueComment: {
+		Selector: `//*[@id="issuecomment-{id}"]/../../..`,
+		SizeFrom: repoContainer,
+		Delay:    "5s",
+	},
+	// review comment 的锚点是 #discussion_r{id}，截取它所在的整个对话
+	PageReviewComment: {
+		Selector: `//*[@id="discussion_r{id}"]/ancestor::*[contains(@class,"js-comment-container") or contains(@class,"review-thread-component")][1]`,
+		Fallback: "#discussion_r{id}",
+		WaitFor:  repoContainer,
+		SizeFrom: repoContainer,
+	},
+	PageRelease: {
+		Selector: repoContainer,
+	},
+}
+
+// LoadSelectorRules 从json文件加载截图规则，格式为 页面类型 -> 规则，页面类型或 delay 写错时返回错误
+func LoadSelectorRules(file string) (map[string]SelectorRule, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules map[string]SelectorRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+	pages := make([]string, 0, len(rules))
+	for page := range rules {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	for _, page := range pages {
+		if _, ok := defaultSelectorRules[page]; !ok {
+			return nil, errors.New("unknown page type " + page)
+		}
+		if delay := rules[page].Delay; delay != "" {
+			if d, err := time.ParseDuration(delay); err != nil || d < 0 {
+				errmsg := fmt.Sprintf("invalid delay %q of %s", delay, page)
+				return nil, errors.New(errmsg)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// selectorRule 页面类型的截图规则，配置的规则中为空的字段使用内置的规则
+func selectorRule(rules map[string]SelectorRule, page string) SelectorRule {
+	rule := defaultSelectorRules[page]
+	custom, ok := rules[page]
+	if !ok {
+		return rule
+	}
+	if custom.Selector != "" {
+		rule.Selector = custom.Selector
+	}
+	if custom.Fallback != "" {
+		rule.Fallback = custom.Fallback
+	}
+	if custom.WaitFor != "" {
+		rule.WaitFor = custom.WaitFor
+	}
+	if custom.SizeFrom != "" {
+		rule.SizeFrom = custom.SizeFrom
+	}
+	if custom.Remove != nil {
+		rule.Remove = custom.Remove
+	}
+	if custom.Delay != "" {
+		rule.Delay = custom.Delay
+	}
+	return rule
+}
+
+// request 按规则生成截图请求，id 为评论的id
+func (r SelectorRule) request(url, id, version string) *RenderRequest {
+	fill := func(s string) string {
+		return strings.ReplaceAll(s, "{id}", id)
+	}
+	req := &RenderRequest{
+		URL:      url,
+		Selector: fill(r.Selector),
+		Fallback: fill(r.Fallback),
+		WaitFor:  fill(r.WaitFor),
+		SizeFrom: fill(r.SizeFrom),
+		Version:  version,
+	}
+	for _, selector := range r.Remove {
+		req.Remove = append(req.Remove, fill(selector))
+	}
+	req.Delay, _ = time.ParseDuration(r.Delay)
+	return req
+}
@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tebeka/selenium"
+	"github.com/tebeka/selenium/chrome"
+	"github.com/tebeka/selenium/firefox"
+)
+
+// SeleniumRenderer 通过 selenium 的 chrome 或 firefox 截图，会话从会话池中复用
+type SeleniumRenderer struct {
+	cfg      RendererConfig
+	Sessions *SessionPool
+}
+
+// NewSeleniumRenderer 初始化，会话在第一次截图时才创建
+func NewSeleniumRenderer(cfg RendererConfig) *SeleniumRenderer {
+	r := &SeleniumRenderer{cfg: cfg}
+	r.Sessions = NewSessionPool(r.newSession, r.resetWindow, cfg.PoolSize, cfg.IdleTimeout)
+	return r
+}
+
+// Render 打开页面，删除不需要的元素，按元素大小调整窗口后截取元素
+func (r *SeleniumRenderer) Render(ctx context.Context, req *RenderRequest) ([]byte, error) {
+	wd, err := r.Sessions.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Sessions.Put(wd)
+	if err := wd.Get(req.URL); err != nil {
+		return nil, err
+	}
+	if unavailable, _ := wd.ExecuteScript("return "+unavailableScript, nil); unavailable == true {
+		return nil, ErrPageUnavailable
+	}
+	_ = wd.Wait(func(wd selenium.WebDriver) (bool, error) {
+		_, err := findElement(wd, req.waitFor())
+		return err == nil, nil
+	})
+	for _, selector := range req.Remove {
+		if elem, err := findElement(wd, selector); err == nil {
+			_, _ = wd.ExecuteScript("arguments[0].remove();", []interface{}{elem})
+		}
+	}
+	sizeEle, err := findElement(wd, req.sizeFrom())
+	if err != nil {
+		return nil, err
+	}
+	elem, err := findElement(wd, req.Selector)
+	if err != nil && req.Fallback != "" {
+		// 页面结构变化时退回到 Fallback
+		elem, err = findElement(wd, req.Fallback)
+	}
+	if err != nil {
+		return nil, err
+	}
+	size, err := sizeEle.Size()
+	if err != nil {
+		return nil, err
+	}
+	window, _ := wd.CurrentWindowHandle()
+	_ = wd.ResizeWindow(window, size.Width, size.Height+100)
+	if req.Delay > 0 {
+		time.Sleep(req.Delay)
+	}
+	return elem.Screenshot(false)
+}
+
+// Close 关闭所有会话
+func (r *SeleniumRenderer) Close() error {
+	r.Sessions.Close()
+	return nil
+}
+
+// findElement 按 XPath 或 CSS 选择器查找元素
+func findElement(wd selenium.WebDriver, selector string) (selenium.WebElement, error) {
+	if isXPath(selector) {
+		return wd.FindElement(selenium.ByXPATH, selector)
+	}
+	return wd.FindElement(selenium.ByCSSSelector, selector)
+}
+
+// resetWindow 截图时会按页面调整窗口大小，放回会话池前恢复成默认大小
+func (r *SeleniumRenderer) resetWindow(wd selenium.WebDriver) error {
+	window, err := wd.CurrentWindowHandle()
+	if err != nil {
+		return err
+	}
+	return wd.ResizeWindow(window, r.cfg.WindowWidth, r.cfg.WindowHeight)
+}
+
+// newSession 按配置初始化 chrome 或 firefox 的 webdriver，配置了登录 cookie 时注入
+func (r *SeleniumRenderer) newSession() (selenium.WebDriver, error) {
+	var (
+		wd  selenium.WebDriver
+		err error
+	)
+	if r.cfg.Type == RendererSeleniumFirefox {
+		wd, err = r.newFirefox()
+	} else {
+		wd, err = r.newChrome()
+	}
+	if err != nil || len(r.cfg.Cookies) == 0 {
+		return wd, err
+	}
+	if err := r.addCookies(wd); err != nil {
+		_ = wd.Quit()
+		return nil, errors.New("add cookies: " + err.Error())
+	}
+	return wd, nil
+}
+
+// addCookies 注入登录 cookie，webdriver 只能给当前打开的站点设置 cookie，需要先打开 github
+func (r *SeleniumRenderer) addCookies(wd selenium.WebDriver) error {
+	if err := wd.Get(cookieURL); err != nil {
+		return err
+	}
+	// expiry 为0时部分浏览器会当作已过期
+	expiry := uint(time.Now().AddDate(1, 0, 0).Unix())
+	for _, c := range r.cfg.Cookies {
+		if err := wd.AddCookie(&selenium.Cookie{Name: c.Name, Value: c.Value, Path: "/", Secure: true, Expiry: expiry}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newChrome 初始化chrome的webdriver
+func (r *SeleniumRenderer) newChrome() (selenium.WebDriver, error) {
+	selenium.HTTPClient = &http.Client{
+		Timeout: time.Second * 30,
+	}
+	caps := selenium.Capabilities{"browserName": "chrome"}
+	// chrome参数
+	chromeCaps := chrome.Capabilities{
+		Args: []string{
+			"--headless", // 设置Chrome无头模式，在linux下运行，需要设置这个参数，否则会报错
+			"--disable-gpu",
+			// "--no-sandbox",
+			fmt.Sprintf("--window-size=%d,%d", r.cfg.WindowWidth, r.cfg.WindowHeight),
+			// fmt.Sprintf("--proxy-server=%s", "http://192.168.28.101:7890"), // --proxy-server=http://127.0.0.1:1234
+		},
+		W3C: true,
+	}
+	if r.cfg.Scale != 1 {
+		chromeCaps.Args = append(chromeCaps.Args, fmt.Sprintf("--force-device-scale-factor=%g", r.cfg.Scale))
+	}
+	// 模拟 prefers-color-scheme，0 为暗色，1 为亮色。需要 Chrome 86 及以上，
+	// 更早的版本还有 no-preference，取值为 0 no-preference、1 暗色、2 亮色
+	switch r.cfg.Theme {
+	case ThemeDark:
+		chromeCaps.Args = append(chromeCaps.Args, "--blink-settings=preferredColorScheme=0")
+	case ThemeLight:
+		chromeCaps.Args = append(chromeCaps.Args, "--blink-settings=preferredColorScheme=1")
+	}
+	if r.cfg.ProfileDir != "" {
+		chromeCaps.Args = append(chromeCaps.Args, "--user-data-dir="+r.cfg.ProfileDir)
+	}
+	caps.AddChrome(chromeCaps)
+	return selenium.NewRemote(caps, r.cfg.Addr)
+}
+
+// newFirefox 初始化firefox的webdriver
+func (r *SeleniumRenderer) newFirefox() (selenium.WebDriver, error) {
+	selenium.HTTPClient = &http.Client{
+		Timeout: time.Second * 60,
+	}
+	caps := selenium.Capabilities{"browserName": "firefox"}
+	// firefox 参数
+	firefoxCaps := firefox.Capabilities{
+		Args: []string{
+			"--headless", // 设置Chrome无头模式，在linux下运行，需要设置这个参数，否则会报错
+			// "--disable-gpu",
+			fmt.Sprintf("window-size=%d,%d", r.cfg.WindowWidth, r.cfg.WindowHeight),
+			// "--no-sandbox",
+			// fmt.Sprintf("--proxy-server=%s", "http://192.168.28.101:7890"), // --proxy-server=http://127.0.0.1:1234
+		},
+	}
+	firefoxCaps.Prefs = map[string]interface{}{
+		"layout.css.devPixelsPerPx": strconv.FormatFloat(r.cfg.Scale, 'f', -1, 64),
+	}
+	switch r.cfg.Theme {
+	case ThemeDark:
+		firefoxCaps.Prefs["ui.systemUsesDarkTheme"] = 1
+		firefoxCaps.Prefs["layout.css.prefers-color-scheme.content-override"] = 0
+	case ThemeLight:
+		firefoxCaps.Prefs["ui.systemUsesDarkTheme"] = 0
+		firefoxCaps.Prefs["layout.css.prefers-color-scheme.content-override"] = 1
+	}
+	if r.cfg.ProfileDir != "" {
+		firefoxCaps.Args = append(firefoxCaps.Args, "-profile", r.cfg.ProfileDir)
+	}
+	caps.AddFirefox(firefoxCaps)
+	return selenium.NewRemote(caps, r.cfg.Addr)
+}
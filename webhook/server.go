@@ -2,35 +2,46 @@
 package webhook
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 )
 
-// ErrInvalidEventFormat 错误信息
-var ErrInvalidEventFormat = errors.New("unable to parse event string. Invalid Format")
+var (
+	// ErrInvalidEventFormat 错误信息
+	ErrInvalidEventFormat = errors.New("unable to parse event string. Invalid Format")
+	// ErrInvalidJSON 请求体不是合法的json
+	ErrInvalidJSON = errors.New("error json request")
+	// ErrIgnoredEvent 不需要处理的event，比如tag、删除分支的push
+	ErrIgnoredEvent = errors.New("ignored event")
+)
 
 // Event 类
 type Event struct {
-	Owner      string       // The username of the owner of the repository
-	Repo       string       // The name of the repository
-	Branch     string       // The branch the event took place on
-	FromUser   string       // 谁fork、start、pr
-	Tag        string       //
-	Commit     string       // The head commit hash attached to the event
-	Type       string       // Can be either "pull_request" or "push"
-	Action     string       // For Pull Requests, contains "assigned", "unassigned", "labeled", "unlabeled", "opened", "closed", "reopened", or "synchronize".
-	BaseOwner  string       // For Pull Requests, contains the base owner
-	BaseRepo   string       // For Pull Requests, contains the base repo
-	BaseBranch string       // For Pull Requests, contains the base branch
-	Payload    gjson.Result // 对象化的json数据
+	Provider       string       // 事件来源，github、gitlab、gitea，为空时表示github
+	Owner          string       // The username of the owner of the repository
+	Repo           string       // The name of the repository
+	Branch         string       // The branch the event took place on
+	FromUser       string       // 谁fork、start、pr
+	Tag            string       //
+	Commit         string       // The head commit hash attached to the event
+	Type           string       // Can be either "pull_request" or "push"
+	Action         string       // For Pull Requests, contains "assigned", "unassigned", "labeled", "unlabeled", "opened", "closed", "reopened", or "synchronize".
+	BaseOwner      string       // For Pull Requests, contains the base owner
+	BaseRepo       string       // For Pull Requests, contains the base repo
+	BaseBranch     string       // For Pull Requests, contains the base branch
+	DeliveryID     string       // X-GitHub-Delivery，每次投递的唯一id
+	Organization   string       // 仓库所属的组织，github app、组织的webhook才有
+	InstallationID int64        // github app 的 installation id，不是 github app 的webhook时为0
+	Offset         int64        // 在事件日志中的偏移，没有写入事件日志时为0
+	Payload        gjson.Result // 对象化的json数据
 }
 
 // NewEvent Create a new event from a string, the string format being the same as the one produced by event.String()
@@ -41,8 +52,8 @@ func NewEvent(e string) (*Event, error) {
 	// Split into lines
 	parts := strings.Split(e, "\n")
 
-	// Sanity checking
-	if len(parts) != 5 || len(parts) != 8 {
+	// Sanity checking: 5 lines for a push, 8 (old) or 9 (new) for a pull_request.
+	if len(parts) != 5 && len(parts) != 8 && len(parts) != 9 {
 		return nil, ErrInvalidEventFormat
 	}
 	for _, item := range parts {
@@ -97,13 +108,39 @@ func (e *Event) String() (output string) {
 	return
 }
 
+// FullName 事件所属仓库的 owner/repo。
+// pull_request 的 Owner/Repo 是 head 仓库（可能是fork），这里优先使用 payload 里的 repository.full_name
+func (e *Event) FullName() string {
+	if name := e.Payload.Get("repository.full_name").String(); name != "" {
+		return name
+	}
+	if e.BaseOwner != "" && e.BaseRepo != "" {
+		return e.BaseOwner + "/" + e.BaseRepo
+	}
+	return e.Owner + "/" + e.Repo
+}
+
+// IsGitHub 是否是github的事件，截图、opengraph图片只支持github
+func (e *Event) IsGitHub() bool {
+	return e.Provider == "" || e.Provider == ProviderGitHub
+}
+
 // Server 服务类
 type Server struct {
-	Port       int        // Port to listen on. Defaults to 80
-	Path       string     // Path to receive on. Defaults to "/postreceive"
-	Secret     string     // Option secret key for authenticating via HMAC
-	IgnoreTags bool       // If set to false, also execute command if tag is pushed
-	Events     chan Event // Channel of events. Read from this channel to get push events as they happen.
+	Port         int        // Port to listen on. Defaults to 80
+	Path         string     // Path to receive on. Defaults to "/postreceive"
+	Secret       string     // Option secret key for authenticating via HMAC
+	Secrets      []string   // 额外的secret列表，任意一个校验通过即可，用于轮换secret
+	AllowSHA1    bool       // 是否允许回退到已废弃的 X-Hub-Signature (sha1) 校验
+	GitLabPath   string     // 接收gitlab投递的路径，为空时不接收gitlab的投递
+	GitLabTokens []string   // gitlab 的 X-Gitlab-Token，为空时拒绝gitlab的投递
+	GiteaPath    string     // 接收gitea、forgejo投递的路径，为空时不接收gitea的投递
+	GiteaSecrets []string   // gitea 的签名secret，为空时拒绝gitea的投递
+	IgnoreTags   bool       // If set to false, also execute command if tag is pushed
+	Events       chan Event // Channel of events. Read from this channel to get push events as they happen.
+	Deduper      *Deduper   // 不为nil时，重复的 X-GitHub-Delivery 只返回200，不再放入 Events
+	EventLog     *EventLog  // 不为nil时，收到的投递会先写入事件日志
+	mu           sync.Mutex // 按收到的顺序写入事件日志和放入 Events
 }
 
 // NewServer Create a new server with sensible defaults.
@@ -134,10 +171,10 @@ func (s *Server) GoListenAndServe() {
 
 // ignoreRef Checks if the given ref should be ignored
 func (s *Server) ignoreRef(rawRef string) bool {
-	if rawRef[:10] == "refs/tags/" && !s.IgnoreTags {
+	if strings.HasPrefix(rawRef, "refs/tags/") && !s.IgnoreTags {
 		return false
 	}
-	return rawRef[:11] != "refs/heads/"
+	return !strings.HasPrefix(rawRef, "refs/heads/")
 }
 
 // ServeHTTP Satisfies the http.Handler interface.
@@ -147,72 +184,175 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	if req.Method != "POST" {
-		http.Error(w, "405 Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "405 Method not allowed")
+		return
+	}
+	if s.GitLabPath != "" && req.URL.Path == s.GitLabPath {
+		s.serveGitLab(w, req)
+		return
+	}
+	if s.GiteaPath != "" && req.URL.Path == s.GiteaPath {
+		s.serveGitea(w, req)
 		return
 	}
 	if req.URL.Path != s.Path {
-		http.Error(w, "404 Not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "404 Not found")
 		return
 	}
 
 	eventType := req.Header.Get("X-GitHub-Event")
 	if eventType == "" {
-		http.Error(w, "400 Bad Request - Missing X-GitHub-Event Header", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "400 Bad Request - Missing X-GitHub-Event Header")
 		return
 	}
 	if !allowEvent(eventType) {
-		http.Error(w, "400 Bad Request - Unknown Event Type "+eventType, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "400 Bad Request - Unknown Event Type "+eventType)
 		return
 	}
 
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// If we have a Secret set, we should check the MAC
-	if s.Secret != "" {
-		sig := req.Header.Get("X-Hub-Signature")
+	if err := s.verifySignature(body, req.Header.Get("X-Hub-Signature-256"), req.Header.Get("X-Hub-Signature")); err != nil {
+		writeError(w, http.StatusForbidden, "403 Forbidden - "+err.Error())
+		return
+	}
 
-		if sig == "" {
-			http.Error(w, "403 Forbidden - Missing X-Hub-Signature required for HMAC verification", http.StatusForbidden)
-			return
-		}
+	s.accept(w, req, eventType, req.Header.Get("X-GitHub-Delivery"), body, func() (*Event, error) {
+		return s.parseEvent(eventType, body)
+	})
+}
 
-		mac := hmac.New(sha1.New, []byte(s.Secret))
-		mac.Write(body)
-		expectedMAC := mac.Sum(nil)
-		expectedSig := "sha1=" + hex.EncodeToString(expectedMAC)
-		if !hmac.Equal([]byte(expectedSig), []byte(sig)) {
-			http.Error(w, "403 Forbidden - HMAC verification failed", http.StatusForbidden)
-			return
-		}
+// accept 校验通过后的公共处理：解析、去重、写入事件日志、放入 Events
+func (s *Server) accept(w http.ResponseWriter, req *http.Request, eventType, deliveryID string, body []byte, parse func() (*Event, error)) {
+	event, err := parse()
+	if errors.Is(err, ErrIgnoredEvent) {
+		writeResponse(w, http.StatusOK, &Response{
+			DeliveryID: deliveryID,
+			Type:       eventType,
+			Status:     StatusFiltered,
+		})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	request := gjson.ParseBytes(body)
-	if !gjson.ValidBytes(body) {
-		http.Error(w, "error json request", http.StatusInternalServerError)
+	event.DeliveryID = deliveryID
+	if s.Deduper != nil && s.Deduper.Seen(event.DeliveryID) {
+		writeResponse(w, http.StatusOK, event.response(StatusDeduplicated))
 		return
 	}
 
-	// Parse the request and build the Event
-	event := Event{}
+	// 先写入事件日志，重启后可以从日志中恢复还没处理的event。
+	// 写入和放入 Events 在同一个锁内，保证 Events 的顺序和事件日志的偏移一致
+	s.mu.Lock()
+	if s.EventLog != nil {
+		if event.Offset, err = s.EventLog.Append(req.URL.Path, req.Header, body); err != nil {
+			log.Errorf("append delivery %s to event log err:%v", event.DeliveryID, err)
+		}
+	}
+	// We've built our Event - put it into the channel and we're done
+	s.Events <- *event
+	s.mu.Unlock()
+
+	writeResponse(w, http.StatusOK, event.response(StatusQueued))
+}
+
+// 投递的处理结果
+const (
+	StatusQueued       = "queued"       // 已放入 Events
+	StatusFiltered     = "filtered"     // 不需要处理，比如tag的push
+	StatusDeduplicated = "deduplicated" // 重复的投递
+	StatusError        = "error"        // 出错
+)
+
+// Response ServeHTTP 返回的json
+type Response struct {
+	DeliveryID string `json:"delivery_id,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// response event 对应的返回
+func (e *Event) response(status string) *Response {
+	return &Response{
+		DeliveryID: e.DeliveryID,
+		Type:       e.Type,
+		Action:     e.Action,
+		Status:     status,
+	}
+}
+
+// writeResponse 输出json
+func writeResponse(w http.ResponseWriter, code int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// writeError 输出json格式的错误
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeResponse(w, code, &Response{Status: StatusError, Error: msg})
+}
+
+// EventFromRecord 把事件日志中的记录重新解析成 Event
+func (s *Server) EventFromRecord(r *Record) (*Event, error) {
+	var (
+		event *Event
+		err   error
+	)
+	if gitlabEvent := r.Header.Get("X-Gitlab-Event"); gitlabEvent != "" {
+		event, err = s.parseGitLabEvent(gitlabEvent, r.Body)
+	} else if giteaEvent := giteaHeader(r.Header, "Event"); giteaEvent != "" {
+		event, err = s.parseGiteaEvent(giteaEvent, r.Body)
+	} else {
+		event, err = s.parseEvent(r.Header.Get("X-GitHub-Event"), r.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	event.DeliveryID = r.DeliveryID()
+	event.Offset = r.Offset
+	return event, nil
+}
+
+// parseEvent Parse the request and build the Event
+func (s *Server) parseEvent(eventType string, body []byte) (*Event, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, ErrInvalidJSON
+	}
+	request := gjson.ParseBytes(body)
+	event := &Event{}
+	event.Provider = ProviderGitHub
 	event.Payload = request
 	event.Type = eventType
 	switch eventType {
 	case "push":
 		rawRef := request.Get("ref").String()
-		// If the ref is not a branch, we don't care about it
-		if s.ignoreRef(rawRef) || request.Get("head_commit").Exists() {
-			return
+		// If the ref is not a branch, we don't care about it. Deleted branches have no commits to notify
+		if s.ignoreRef(rawRef) || request.Get("deleted").Bool() {
+			return nil, ErrIgnoredEvent
+		}
+		if strings.HasPrefix(rawRef, "refs/tags/") {
+			event.Tag = strings.TrimPrefix(rawRef, "refs/tags/")
+		} else {
+			event.Branch = strings.TrimPrefix(rawRef, "refs/heads/")
 		}
-		event.Branch = rawRef[11:]
+		event.FromUser = request.Get("sender.login").String()
 		event.Repo = request.Get("repository.name").String()
 		event.Commit = request.Get("head_commit.id").String()
-		event.Owner = request.Get("repository.owner.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
 	case "pull_request":
 		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
 		event.Owner = request.Get("pull_request.head.repo.owner.login").String()
 		event.Repo = request.Get("pull_request.head.repo.name").String()
 		event.Branch = request.Get("pull_request.head.ref").String()
@@ -222,6 +362,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		event.BaseBranch = request.Get("pull_request.base.ref").String()
 	case "pull_request_review":
 		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
 		event.Owner = request.Get("pull_request.head.repo.owner.login").String()
 		event.Repo = request.Get("pull_request.head.repo.name").String()
 		event.Branch = request.Get("pull_request.head.ref").String()
@@ -231,6 +372,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		event.BaseBranch = request.Get("pull_request.base.ref").String()
 	case "pull_request_review_comment":
 		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
 		event.Owner = request.Get("pull_request.head.repo.owner.login").String()
 		event.Repo = request.Get("pull_request.head.repo.name").String()
 		event.Branch = request.Get("pull_request.head.ref").String()
@@ -266,21 +408,99 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		event.FromUser = request.Get("sender.login").String()
 		event.Repo = request.Get("repository.name").String()
 		event.Owner = request.Get("repository.owner.login").String()
+	case "ping":
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		if event.Owner == "" {
+			event.Owner = request.Get("organization.login").String()
+		}
+	case "workflow_run":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Branch = request.Get("workflow_run.head_branch").String()
+		event.Commit = request.Get("workflow_run.head_sha").String()
+	case "workflow_job":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Branch = request.Get("workflow_job.head_branch").String()
+		event.Commit = request.Get("workflow_job.head_sha").String()
+	case "check_run":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Branch = request.Get("check_run.check_suite.head_branch").String()
+		event.Commit = request.Get("check_run.head_sha").String()
+	case "check_suite":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Branch = request.Get("check_suite.head_branch").String()
+		event.Commit = request.Get("check_suite.head_sha").String()
+	case "status":
+		// status 没有action，使用 state (pending、success、failure、error) 作为action
+		event.Action = request.Get("state").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Branch = request.Get("branches.0.name").String()
+		event.Commit = request.Get("sha").String()
+	case "deployment", "deployment_status":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Branch = request.Get("deployment.ref").String()
+		event.Commit = request.Get("deployment.sha").String()
+	case "member", "public", "repository", "branch_protection_rule", "label", "milestone",
+		"discussion", "discussion_comment", "gollum", "dependabot_alert":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+	case "delete":
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		if request.Get("ref_type").String() == "tag" {
+			event.Tag = request.Get("ref").String()
+		} else {
+			event.Branch = request.Get("ref").String()
+		}
+	case "commit_comment":
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Repo = request.Get("repository.name").String()
+		event.Owner = request.Get("repository.owner.login").String()
+		event.Commit = request.Get("comment.commit_id").String()
+	case "security_advisory":
+		// 全局的安全公告，不属于某个仓库
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+	case "installation", "installation_repositories":
+		// 安装到账号上的github app，可能包含多个仓库
+		event.Action = request.Get("action").String()
+		event.FromUser = request.Get("sender.login").String()
+		event.Owner = request.Get("installation.account.login").String()
 	case "create":
 		event.FromUser = request.Get("sender.login").String()
 		event.Repo = request.Get("repository.name").String()
 		event.Owner = request.Get("repository.owner.login").String()
 	default:
-		http.Error(w, "Unknown Event Type "+eventType, http.StatusInternalServerError)
-		return
+		return nil, errors.New("unknown event type " + eventType)
 	}
-
-	// We've built our Event - put it into the channel and we're done
-	go func() {
-		s.Events <- event
-	}()
-
-	_, _ = w.Write([]byte(event.String()))
+	event.Organization = request.Get("organization.login").String()
+	if event.Organization == "" && request.Get("installation.account.type").String() == "Organization" {
+		event.Organization = request.Get("installation.account.login").String()
+	}
+	event.InstallationID = request.Get("installation.id").Int()
+	return event, nil
 }
 
 // allowEvent 支持解析的event类型
@@ -346,6 +566,90 @@ func allowEvent(eventType string) bool {
 		"issues", //
 
 		"create", // A Git branch or tag is created. For more information, see the "Git database" REST API.
+
+		"ping", // 配置webhook时github发送的测试event
+
+		/**
+		The action performed. Can be requested, in_progress or completed.
+		*/
+		"workflow_run", // github actions 工作流
+		/**
+		The action performed. Can be queued, in_progress, waiting or completed.
+		*/
+		"workflow_job", // github actions 工作流中的job
+		/**
+		The action performed. Can be created, completed, rerequested or requested_action.
+		*/
+		"check_run", //
+		/**
+		The action performed. Can be completed, requested or rerequested.
+		*/
+		"check_suite", //
+		"status",      // commit status，state 为 pending、success、failure 或 error
+
+		/**
+		The action performed. Can be created.
+		*/
+		"deployment", // 创建部署
+		/**
+		The action performed. Can be created. deployment_status.state can be one of
+		error, failure, inactive, in_progress, queued, pending or success.
+		*/
+		"deployment_status", // 部署状态变化
+
+		"delete", // A Git branch or tag is deleted.
+		/**
+		The action that was performed. Can be added, removed or edited.
+		*/
+		"member", // 仓库协作者变化
+		"public", // 仓库从私有改为公开
+		/**
+		The action that was performed. Can be created, deleted, archived, unarchived, edited, renamed, transferred, publicized or privatized.
+		*/
+		"repository", //
+		/**
+		The action performed. Can be created, edited or deleted.
+		*/
+		"branch_protection_rule", // 分支保护规则
+		/**
+		The action that was performed. Can be created, edited or deleted.
+		*/
+		"label", //
+		/**
+		The action that was performed. Can be created, closed, opened, edited or deleted.
+		*/
+		"milestone", //
+		/**
+		The action performed. Can be created, edited, deleted, pinned, unpinned, locked, unlocked, transferred,
+		category_changed, answered, unanswered, labeled or unlabeled.
+		*/
+		"discussion", //
+		/**
+		The action performed. Can be created, edited or deleted.
+		*/
+		"discussion_comment", //
+		"gollum",             // wiki 页面的创建和修改
+		/**
+		The action performed. Can be created.
+		*/
+		"commit_comment", // commit 的评论
+		/**
+		The action that was performed. Can be published, updated, performed or withdrawn.
+		*/
+		"security_advisory", // github 的安全公告
+		/**
+		The action that was performed. Can be created, dismissed, fixed, reintroduced, reopened or auto_dismissed.
+		*/
+		"dependabot_alert", // 依赖的安全告警
+
+		/**
+		The action that was performed. Can be created, deleted, suspend, unsuspend or new_permissions_accepted.
+		*/
+		"installation", // github app 的安装
+		/**
+		The action that was performed. Can be either added or removed.
+		*/
+		"installation_repositories", // github app 安装的仓库变化
 	}
 	for _, s := range allow {
 		if s == eventType {
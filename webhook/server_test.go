@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestServeHTTPResponse 测试 ServeHTTP 返回的json
+func TestServeHTTPResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		body      string
+		wantCode  int
+		want      Response
+	}{
+		{
+			name:      "ping",
+			eventType: "ping",
+			body:      `{"zen":"Keep it logically awesome.","hook_id":30,"hook":{"type":"Repository","events":["push","issues"]},"repository":{"name":"hello","full_name":"octocat/hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}}`,
+			wantCode:  http.StatusOK,
+			want:      Response{DeliveryID: "1", Type: "ping", Status: StatusQueued},
+		},
+		{
+			name:      "queued",
+			eventType: "issues",
+			body:      `{"action":"opened","repository":{"name":"hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}}`,
+			wantCode:  http.StatusOK,
+			want:      Response{DeliveryID: "1", Type: "issues", Action: "opened", Status: StatusQueued},
+		},
+		{
+			name:      "filtered tag push",
+			eventType: "push",
+			body:      `{"ref":"refs/tags/v1.0.0"}`,
+			wantCode:  http.StatusOK,
+			want:      Response{DeliveryID: "1", Type: "push", Status: StatusFiltered},
+		},
+		{
+			name:      "unknown event",
+			eventType: "unknown",
+			body:      `{}`,
+			wantCode:  http.StatusBadRequest,
+			want:      Response{Status: StatusError, Error: "400 Bad Request - Unknown Event Type unknown"},
+		},
+		{
+			name:      "invalid json",
+			eventType: "star",
+			body:      `{`,
+			wantCode:  http.StatusInternalServerError,
+			want:      Response{Status: StatusError, Error: ErrInvalidJSON.Error()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+			req := httptest.NewRequest(http.MethodPost, s.Path, strings.NewReader(tt.body))
+			req.Header.Set("X-GitHub-Event", tt.eventType)
+			req.Header.Set("X-GitHub-Delivery", "1")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", w.Code, tt.wantCode)
+			}
+			var got Response
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("response %q is not json: %v", w.Body.String(), err)
+			}
+			if got != tt.want {
+				t.Errorf("ServeHTTP() = %+v, want %+v", got, tt.want)
+			}
+			if tt.want.Status == StatusQueued {
+				select {
+				case event := <-s.Events:
+					if event.Type != tt.eventType {
+						t.Errorf("event type = %s, want %s", event.Type, tt.eventType)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("event not queued")
+				}
+			}
+		})
+	}
+}
+
+// TestServeHTTPOrder 测试并发收到的投递按事件日志的偏移顺序放入 Events
+func TestServeHTTPOrder(t *testing.T) {
+	l, err := OpenEventLog(t.TempDir(), 100, 0)
+	if err != nil {
+		t.Fatalf("OpenEventLog err %v", err)
+	}
+	defer l.Close()
+	s := NewServer()
+	s.EventLog = l
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, s.Path, strings.NewReader(`{"action":"created","repository":{"name":"hello","owner":{"login":"octocat"}}}`))
+			req.Header.Set("X-GitHub-Event", "star")
+			req.Header.Set("X-GitHub-Delivery", strconv.Itoa(i))
+			s.ServeHTTP(httptest.NewRecorder(), req)
+		}(i)
+	}
+	for i := 1; i <= n; i++ {
+		if event := <-s.Events; event.Offset != int64(i) {
+			t.Fatalf("event offset = %d, want %d", event.Offset, i)
+		}
+	}
+	wg.Wait()
+}
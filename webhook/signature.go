@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint: gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+)
+
+var (
+	// ErrMissingSignature 请求头里没有签名
+	ErrMissingSignature = errors.New("missing X-Hub-Signature-256 required for HMAC verification")
+	// ErrSignatureMismatch 签名校验不通过
+	ErrSignatureMismatch = errors.New("HMAC verification failed")
+	// ErrSHA1NotAllowed 只带了已废弃的sha1签名，但没有允许sha1
+	ErrSHA1NotAllowed = errors.New("X-Hub-Signature (sha1) is deprecated and not allowed, X-Hub-Signature-256 required")
+)
+
+// secrets 返回所有可用于校验的secret，Secret 排在 Secrets 前面
+func (s *Server) secrets() []string {
+	list := make([]string, 0, len(s.Secrets)+1)
+	if s.Secret != "" {
+		list = append(list, s.Secret)
+	}
+	for _, secret := range s.Secrets {
+		if secret != "" {
+			list = append(list, secret)
+		}
+	}
+	return list
+}
+
+// verifySignature 校验github的签名。
+// 优先校验 X-Hub-Signature-256，只有在 AllowSHA1 为 true 且没有 sha256 签名时，才会回退校验 X-Hub-Signature
+func (s *Server) verifySignature(body []byte, sig256, sig1 string) error {
+	secrets := s.secrets()
+	if len(secrets) == 0 {
+		return nil
+	}
+	switch {
+	case sig256 != "":
+		if checkSignature(sha256.New, "sha256=", secrets, body, sig256) {
+			return nil
+		}
+		return ErrSignatureMismatch
+	case sig1 != "":
+		if !s.AllowSHA1 {
+			return ErrSHA1NotAllowed
+		}
+		if checkSignature(sha1.New, "sha1=", secrets, body, sig1) {
+			return nil
+		}
+		return ErrSignatureMismatch
+	default:
+		return ErrMissingSignature
+	}
+}
+
+// checkSignature 依次用每个secret计算签名，任意一个匹配即通过，便于轮换secret
+func checkSignature(h func() hash.Hash, prefix string, secrets []string, body []byte, sig string) bool {
+	if !strings.HasPrefix(sig, prefix) {
+		return false
+	}
+	got, err := hex.DecodeString(sig[len(prefix):])
+	if err != nil {
+		return false
+	}
+	for _, secret := range secrets {
+		mac := hmac.New(h, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), got) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSecrets 把逗号分隔的secret列表拆开，用于轮换 GITHUB_WEBHOOK_SECRET
+func ParseSecrets(raw string) []string {
+	return splitList(raw)
+}
+
+// splitList 拆分逗号分隔的列表，去掉空白和空项
+func splitList(raw string) []string {
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
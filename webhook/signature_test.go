@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 来自 github 文档的示例：secret "It's a Secret to Everybody"，payload "Hello, World!"
+const (
+	testSecret  = "It's a Secret to Everybody"
+	testPayload = "Hello, World!"
+	testSig256  = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	testSig1    = "sha1=01dc10d0c83e72ed246219cdd91669667fe2ca59"
+)
+
+// TestVerifySignature 测试签名校验
+func TestVerifySignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		secrets   []string
+		allowSHA1 bool
+		sig256    string
+		sig1      string
+		want      error
+	}{
+		{name: "no secret", sig256: "", want: nil},
+		{name: "sha256 ok", secret: testSecret, sig256: testSig256, want: nil},
+		{name: "sha256 wrong secret", secret: "other", sig256: testSig256, want: ErrSignatureMismatch},
+		{name: "sha256 bad hex", secret: testSecret, sig256: "sha256=zz", want: ErrSignatureMismatch},
+		{name: "sha256 wrong prefix", secret: testSecret, sig256: strings.Replace(testSig256, "sha256=", "sha1=", 1), want: ErrSignatureMismatch},
+		{name: "sha256 preferred over sha1", secret: testSecret, sig256: testSig256, sig1: "sha1=00", want: nil},
+		{name: "sha256 bad does not fall back", secret: testSecret, allowSHA1: true, sig256: "sha256=00", sig1: testSig1, want: ErrSignatureMismatch},
+		{name: "missing", secret: testSecret, want: ErrMissingSignature},
+		{name: "sha1 not allowed", secret: testSecret, sig1: testSig1, want: ErrSHA1NotAllowed},
+		{name: "sha1 allowed", secret: testSecret, allowSHA1: true, sig1: testSig1, want: nil},
+		{name: "sha1 allowed mismatch", secret: "other", allowSHA1: true, sig1: testSig1, want: ErrSignatureMismatch},
+		{name: "rotating old secret", secrets: []string{"new-secret", testSecret}, sig256: testSig256, want: nil},
+		{name: "rotating new secret", secret: "old-secret", secrets: []string{testSecret}, sig256: testSig256, want: nil},
+		{name: "rotating none match", secrets: []string{"a", "b"}, sig256: testSig256, want: ErrSignatureMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Secret: tt.secret, Secrets: tt.secrets, AllowSHA1: tt.allowSHA1}
+			if got := s.verifySignature([]byte(testPayload), tt.sig256, tt.sig1); got != tt.want {
+				t.Errorf("verifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestServeHTTPSignature 测试 ServeHTTP 对签名的处理
+func TestServeHTTPSignature(t *testing.T) {
+	body := `{"action":"created","sender":{"login":"octocat"},"repository":{"name":"hello","owner":{"login":"octocat"}}}`
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{name: "sha256 ok", header: map[string]string{"X-Hub-Signature-256": "sha256=d9e7d113e1aff46c0ee02f297b429ee6edd638502b91b6df456399c90cd1a006"}, want: http.StatusOK},
+		{name: "sha256 mismatch", header: map[string]string{"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"}, want: http.StatusForbidden},
+		{name: "missing", header: map[string]string{}, want: http.StatusForbidden},
+		{name: "sha1 only", header: map[string]string{"X-Hub-Signature": "sha1=00"}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+			s.Secrets = []string{testSecret}
+			req := httptest.NewRequest(http.MethodPost, s.Path, strings.NewReader(body))
+			req.Header.Set("X-GitHub-Event", "star")
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("ServeHTTP() code = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrSubscriptionsCorrupted 订阅文件解析失败，为了不覆盖原文件拒绝修改订阅
+var ErrSubscriptionsCorrupted = errors.New("subscriptions file corrupted, fix it and restart before changing subscriptions")
+
+// Subscription 群对仓库的订阅
+type Subscription struct {
+	Repo   string   `json:"repo"`   // owner/repo，支持glob
+	Events []string `json:"events"` // 订阅的event，为空表示全部
+}
+
+// Subscriptions 群订阅列表，通过聊天命令管理，保存在json文件中
+type Subscriptions struct {
+	mu       sync.RWMutex
+	file     string
+	groups   map[int64][]Subscription
+	readOnly bool // 文件解析失败时只读，避免保存时覆盖原文件
+}
+
+// NewSubscriptions 从文件加载订阅列表，文件不存在时返回空列表。
+// 文件解析失败时返回只读的空列表和错误，修改订阅会返回 ErrSubscriptionsCorrupted
+func NewSubscriptions(file string) (*Subscriptions, error) {
+	s := &Subscriptions{
+		file:   file,
+		groups: make(map[int64][]Subscription),
+	}
+	raw, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(raw, &s.groups); err != nil {
+		s.groups = make(map[int64][]Subscription)
+		s.readOnly = true
+		return s, err
+	}
+	return s, nil
+}
+
+// Exists 订阅文件是否已经存在
+func (s *Subscriptions) Exists() bool {
+	_, err := os.Stat(s.file)
+	return err == nil
+}
+
+// Subscribe 群订阅仓库，已订阅时覆盖event列表
+func (s *Subscriptions) Subscribe(group int64, repo string, events []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return ErrSubscriptionsCorrupted
+	}
+	sub := Subscription{Repo: repo, Events: events}
+	list := s.groups[group]
+	for i := range list {
+		if strings.EqualFold(list[i].Repo, repo) {
+			list[i] = sub
+			return s.save()
+		}
+	}
+	s.groups[group] = append(list, sub)
+	return s.save()
+}
+
+// Unsubscribe 取消群对仓库的订阅，返回是否存在该订阅
+func (s *Subscriptions) Unsubscribe(group int64, repo string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return false, ErrSubscriptionsCorrupted
+	}
+	list := s.groups[group]
+	for i := range list {
+		if strings.EqualFold(list[i].Repo, repo) {
+			list = append(list[:i], list[i+1:]...)
+			if len(list) == 0 {
+				delete(s.groups, group)
+			} else {
+				s.groups[group] = list
+			}
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// List 返回群的订阅列表
+func (s *Subscriptions) List(group int64) []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Subscription(nil), s.groups[group]...)
+}
+
+// Routes 把订阅转换成路由规则
+func (s *Subscriptions) Routes() []Route {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	groups := make([]int64, 0, len(s.groups))
+	for group := range s.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	var routes []Route
+	for _, group := range groups {
+		for _, sub := range s.groups[group] {
+			routes = append(routes, Route{
+				Repos:  []string{sub.Repo},
+				Events: sub.Events,
+				Groups: []int64{group},
+			})
+		}
+	}
+	return routes
+}
+
+// save 写入文件，先写临时文件再重命名，避免写一半
+func (s *Subscriptions) save() error {
+	raw, err := json.MarshalIndent(s.groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.file); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
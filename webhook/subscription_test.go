@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestSubscriptions 测试订阅的增删和持久化
+func TestSubscriptions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subs", "subscriptions.json")
+	subs, err := NewSubscriptions(file)
+	if err != nil {
+		t.Fatalf("NewSubscriptions err %v", err)
+	}
+	if subs.Exists() {
+		t.Fatalf("file should not exist before first save")
+	}
+	if err := subs.Subscribe(100, "scjtqs2/*", nil); err != nil {
+		t.Fatalf("Subscribe err %v", err)
+	}
+	if err := subs.Subscribe(100, "scjtqs2/bot_app_github", []string{"issues"}); err != nil {
+		t.Fatalf("Subscribe err %v", err)
+	}
+	if err := subs.Subscribe(100, "scjtqs2/bot_app_github", []string{"release"}); err != nil {
+		t.Fatalf("Subscribe err %v", err)
+	}
+	if err := subs.Subscribe(200, "other/repo", nil); err != nil {
+		t.Fatalf("Subscribe err %v", err)
+	}
+
+	reloaded, err := NewSubscriptions(file)
+	if err != nil {
+		t.Fatalf("reload err %v", err)
+	}
+	want := []Subscription{
+		{Repo: "scjtqs2/*"},
+		{Repo: "scjtqs2/bot_app_github", Events: []string{"release"}},
+	}
+	if got := reloaded.List(100); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+
+	ok, err := reloaded.Unsubscribe(200, "OTHER/repo")
+	if err != nil || !ok {
+		t.Fatalf("Unsubscribe = %v, %v", ok, err)
+	}
+	if ok, _ := reloaded.Unsubscribe(200, "other/repo"); ok {
+		t.Errorf("Unsubscribe twice should return false")
+	}
+
+	router := NewRouter()
+	router.SetSubscriptions(reloaded)
+	_, groups := router.Match(&Event{Type: "release", Owner: "scjtqs2", Repo: "bot_app_github"})
+	if !reflect.DeepEqual(groups, []int64{100}) {
+		t.Errorf("Match() groups = %v, want [100]", groups)
+	}
+	_, groups = router.Match(&Event{Type: "star", Owner: "other", Repo: "repo"})
+	if len(groups) != 0 {
+		t.Errorf("Match() groups = %v, want none", groups)
+	}
+}
+
+// TestSubscriptionsCorrupted 测试订阅文件损坏时不会被覆盖
+func TestSubscriptionsCorrupted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subscriptions.json")
+	raw := []byte(`{"100": [{"repo": "scjtqs2/*"}`)
+	_ = os.WriteFile(file, raw, 0o644)
+	subs, err := NewSubscriptions(file)
+	if err == nil {
+		t.Fatalf("NewSubscriptions should fail with corrupted file")
+	}
+	if err := subs.Subscribe(200, "other/repo", nil); !errors.Is(err, ErrSubscriptionsCorrupted) {
+		t.Errorf("Subscribe err = %v, want ErrSubscriptionsCorrupted", err)
+	}
+	if got, _ := os.ReadFile(file); string(got) != string(raw) {
+		t.Errorf("corrupted file overwritten: %s", got)
+	}
+}
@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/scjtqs2/bot_adapter/coolq"
+	"github.com/tidwall/gjson"
+)
+
+// defaultTemplates 内置的默认模板
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// templateExt 模板文件后缀
+const templateExt = ".tmpl"
+
+// TemplateData 渲染模板时传入的数据
+type TemplateData struct {
+	Event       *Event       // 解析后的event
+	Payload     gjson.Result // event 的原始 payload
+	Screenshot  []byte       // 页面截图，没有截图时为nil
+	Screenshots [][]byte     // 按最大高度切分后的截图，为空时使用 Screenshot
+	MaxCommits  int          // push 消息最多列出的 commit 数
+	CI          *CIStatus    // CI 类event的状态，其他event为nil
+	States      []string     // 同一个消息串经历过的状态，如部署的 queued、in_progress、success
+}
+
+// Images 按最大高度切分后的截图，没有切分时只有 Screenshot 一张
+func (d *TemplateData) Images() [][]byte {
+	if len(d.Screenshots) > 0 {
+		return d.Screenshots
+	}
+	if d.Screenshot != nil {
+		return [][]byte{d.Screenshot}
+	}
+	return nil
+}
+
+// Str 读取 payload 中的字符串
+func (d *TemplateData) Str(path string) string {
+	return d.Payload.Get(path).String()
+}
+
+// Int 读取 payload 中的整数
+func (d *TemplateData) Int(path string) int64 {
+	return d.Payload.Get(path).Int()
+}
+
+// Bool 读取 payload 中的布尔值
+func (d *TemplateData) Bool(path string) bool {
+	return d.Payload.Get(path).Bool()
+}
+
+// templateFuncs 模板里可用的辅助函数
+var templateFuncs = template.FuncMap{
+	"truncate":    truncate,
+	"labels":      labels,
+	"shortSHA":    shortSHA,
+	"firstLine":   firstLine,
+	"lastLines":   lastLines,
+	"count":       count,
+	"join":        join,
+	"head":        head,
+	"sub":         func(a, b int) int { return a - b },
+	"humanSize":   humanSize,
+	"image":       imageCode,
+	"imageBase64": imageBase64Code,
+}
+
+// truncate 按字符截断文本，超出部分用 ... 表示
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+// labels 把 labels 数组拼成 [bug][help wanted] 的形式
+func labels(result gjson.Result) string {
+	var s string
+	for _, label := range result.Array() {
+		s += fmt.Sprintf("[%s]", label.Get("name").String())
+	}
+	return s
+}
+
+// shortSHA commit 的短 hash
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// firstLine 文本的第一行，用于 commit message 的标题
+func firstLine(s string) string {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// lastLines 文本的最后n行，用于 review comment 的 diff hunk
+func lastLines(n int, s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if n >= 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// count 数组的长度
+func count(result gjson.Result) int {
+	return len(result.Array())
+}
+
+// join 把字符串数组用sep拼起来
+func join(result gjson.Result, sep string) string {
+	list := result.Array()
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		items = append(items, item.String())
+	}
+	return strings.Join(items, sep)
+}
+
+// head 数组的前n个元素
+func head(n int, result gjson.Result) []gjson.Result {
+	list := result.Array()
+	if n >= 0 && len(list) > n {
+		return list[:n]
+	}
+	return list
+}
+
+// humanSize 文件大小，如 1.5 MB
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// imageCode 图片的CQ码
+func imageCode(url string) string {
+	return coolq.EnImageCode(url, 0)
+}
+
+// imageBase64Code 把图片内容编码成 base64 的CQ码
+func imageBase64Code(pic []byte) string {
+	return coolq.EnImageCode("base64://"+base64.StdEncoding.EncodeToString(pic), 0)
+}
+
+// Templates 推送消息的模板集合，按 "类型.action" 或 "类型" 查找
+type Templates struct {
+	mu  sync.RWMutex
+	set *template.Template
+}
+
+// NewTemplates 加载内置模板，dir 不为空时再加载目录中的模板覆盖同名的内置模板
+func NewTemplates(dir string) (*Templates, error) {
+	set := template.New("").Funcs(templateFuncs)
+	if err := parseTemplates(set, defaultTemplates, "templates"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := parseTemplates(set, os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+	}
+	return &Templates{set: set}, nil
+}
+
+// Reload 重新加载模板，加载失败时保留原来的模板
+func (t *Templates) Reload(dir string) error {
+	n, err := NewTemplates(dir)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.set = n.set
+	return nil
+}
+
+// parseTemplates 解析目录下所有的 .tmpl 文件，模板名为去掉后缀的文件名。
+// 文件末尾的一个换行会被去掉，方便编辑
+func parseTemplates(set *template.Template, fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(dir, "*"+templateExt)))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		raw, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), templateExt)
+		text := strings.TrimSuffix(strings.TrimSuffix(string(raw), "\n"), "\r")
+		if _, err := set.New(name).Parse(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup 按 "类型.action"、"类型" 的顺序查找模板
+func (t *Templates) lookup(eventType, action string) *template.Template {
+	if action != "" {
+		if tpl := t.set.Lookup(eventType + "." + action); tpl != nil {
+			return tpl
+		}
+	}
+	return t.set.Lookup(eventType)
+}
+
+// Has 是否存在该event的模板
+func (t *Templates) Has(eventType, action string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.lookup(eventType, action) != nil
+}
+
+// Render 渲染event的推送消息，没有对应的模板时返回空字符串
+func (t *Templates) Render(data *TemplateData) (string, error) {
+	t.mu.RLock()
+	tpl := t.lookup(data.Event.Type, data.Event.Action)
+	t.mu.RUnlock()
+	if tpl == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
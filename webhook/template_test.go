@@ -0,0 +1,479 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/scjtqs2/bot_adapter/coolq"
+	"github.com/tidwall/gjson"
+)
+
+const testIssuePayload = `{
+	"issue": {"number": 1358, "id": 123, "title": "crash", "body": "it crashes", "html_url": "https://github.com/Mrs4s/go-cqhttp/issues/1358",
+		"labels": [{"name": "bug"}, {"name": "help wanted"}]},
+	"comment": {"id": 456, "body": "me too", "html_url": "https://github.com/Mrs4s/go-cqhttp/issues/1358#issuecomment-456"},
+	"pull_request": {"number": 1356, "html_url": "https://github.com/Mrs4s/go-cqhttp/pull/1356"},
+	"repository": {"stargazers_count": 3914, "forks_count": 1024}
+}`
+
+// cqCode 匹配CQ码
+var cqCode = regexp.MustCompile(`\[CQ:[^\]]*\]`)
+
+// normalizeCQ CQ码的参数顺序不固定，排序后再比较
+func normalizeCQ(s string) string {
+	return cqCode.ReplaceAllStringFunc(s, func(code string) string {
+		parts := strings.Split(code[1:len(code)-1], ",")
+		sort.Strings(parts[1:])
+		return "[" + strings.Join(parts, ",") + "]"
+	})
+}
+
+// TestDefaultTemplates 内置模板要和原来硬编码的输出保持一致
+func TestDefaultTemplates(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	payload := gjson.Parse(testIssuePayload)
+	pic := []byte("png")
+	picCode := coolq.EnImageCode(fmt.Sprintf("base64://%s", base64.StdEncoding.EncodeToString(pic)), 0)
+	issueOG := coolq.EnImageCode("https://opengraph.githubassets.com/0/Mrs4s/go-cqhttp/issues/1358", 0)
+	tests := []struct {
+		name       string
+		event      Event
+		screenshot []byte
+		want       string
+	}{
+		{
+			name:  "star",
+			event: Event{Type: "star", Action: "created", FromUser: "Tim-Paik", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want:  "Tim-Paik starred Mrs4s/go-cqhttp (total 3914 stargazers)",
+		},
+		{
+			name:  "unstar",
+			event: Event{Type: "star", Action: "deleted", FromUser: "Tim-Paik", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want:  "Tim-Paik unstarred Mrs4s/go-cqhttp (total 3914 stargazers)",
+		},
+		{
+			name:  "fork",
+			event: Event{Type: "fork", FromUser: "Tim-Paik", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want:  "Tim-Paik forked Mrs4s/go-cqhttp (total 1024 forks_count)",
+		},
+		{
+			name:  "issue opened",
+			event: Event{Type: "issues", Action: "opened", FromUser: "wdvxdr1123", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want: "wdvxdr1123 opened issue Mrs4s/go-cqhttp #1358 \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/issues/1358 \n" +
+				"[bug][help wanted] Title: crash \n" +
+				"Body: it crashes" + issueOG,
+		},
+		{
+			name:  "issue closed",
+			event: Event{Type: "issues", Action: "closed", FromUser: "wdvxdr1123", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want: "wdvxdr1123 closed issue Mrs4s/go-cqhttp #1358 \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/issues/1358 \n" +
+				"[bug][help wanted] Title: crash \n" +
+				"Body: it crashes \n" + issueOG,
+		},
+		{
+			name:       "issue reopened with screenshot",
+			event:      Event{Type: "issues", Action: "reopened", FromUser: "wdvxdr1123", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			screenshot: pic,
+			want: "wdvxdr1123 reopened issue Mrs4s/go-cqhttp #1358 \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/issues/1358 \n" + picCode,
+		},
+		{
+			name:  "issue labeled",
+			event: Event{Type: "issues", Action: "labeled", FromUser: "wdvxdr1123", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want:  "",
+		},
+		{
+			name:  "comment created",
+			event: Event{Type: "issue_comment", Action: "created", FromUser: "a", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want: "a commented on Mrs4s/go-cqhttp #1358 \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/issues/1358#issuecomment-456 \n" +
+				"[bug][help wanted] Title: crash \n" +
+				"Body: it crashes \n" +
+				"Comment: me too \n",
+		},
+		{
+			name:       "comment edited with screenshot",
+			event:      Event{Type: "issue_comment", Action: "edited", FromUser: "a", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			screenshot: pic,
+			want: "a edited commente on Mrs4s/go-cqhttp #1358 \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/issues/1358#issuecomment-456 \n" + picCode,
+		},
+		{
+			name:  "comment deleted",
+			event: Event{Type: "issue_comment", Action: "deleted", FromUser: "a", Owner: "Mrs4s", Repo: "go-cqhttp"},
+			want: "a deleted commente on Mrs4s/go-cqhttp #1358" +
+				"[bug][help wanted] Title: crash \n" +
+				"Body: it crashes \n" +
+				"Comment: me too \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/issues/1358#issuecomment-456 \n",
+		},
+		{
+			name: "pull request opened",
+			event: Event{Type: "pull_request", Action: "opened", FromUser: "wdvxdr1123", Owner: "wdvxdr1123", Repo: "go-cqhttp",
+				Branch: "test_pr_review", BaseOwner: "Mrs4s", BaseRepo: "go-cqhttp", BaseBranch: "dev"},
+			want: "wdvxdr1123 opened an pull request for wdvxdr1123/go-cqhttp #1356 (dev<-wdvxdr1123:test_pr_review) \n" +
+				"jump: https://github.com/Mrs4s/go-cqhttp/pull/1356 \n" +
+				coolq.EnImageCode("https://opengraph.githubassets.com/0/Mrs4s/go-cqhttp/pull/1356", 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := templates.Render(&TemplateData{Event: &tt.event, Payload: payload, Screenshot: tt.screenshot})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if normalizeCQ(got) != normalizeCQ(tt.want) {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTemplateFuncs 测试模板辅助函数
+func TestTemplateFuncs(t *testing.T) {
+	if got := truncate(3, "你好世界"); got != "你好世..." {
+		t.Errorf("truncate() = %q", got)
+	}
+	if got := truncate(10, "hello"); got != "hello" {
+		t.Errorf("truncate() = %q", got)
+	}
+	if got := shortSHA("e9100b7f5ac1aa"); got != "e9100b7" {
+		t.Errorf("shortSHA() = %q", got)
+	}
+}
+
+// TestPushTemplate 测试push消息
+func TestPushTemplate(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	payload := gjson.Parse(`{
+		"forced": true,
+		"compare": "https://github.com/scjtqs2/bot_app_github/compare/aaa...ccc",
+		"commits": [
+			{"id": "aaaaaaaaaa", "message": "fix push\n\nlong description", "author": {"name": "scjtqs"}},
+			{"id": "bbbbbbbbbb", "message": "add release", "author": {"name": "octocat"}},
+			{"id": "cccccccccc", "message": "bump", "author": {"name": "octocat"}}
+		]
+	}`)
+	event := Event{Type: "push", FromUser: "scjtqs2", Owner: "scjtqs2", Repo: "bot_app_github", Branch: "main"}
+	got, err := templates.Render(&TemplateData{Event: &event, Payload: payload, MaxCommits: 2})
+	if err != nil {
+		t.Fatalf("Render err %v", err)
+	}
+	want := "scjtqs2 force-pushed 3 commits to scjtqs2/bot_app_github:main\n" +
+		"[aaaaaaa] fix push - scjtqs\n" +
+		"[bbbbbbb] add release - octocat\n" +
+		"... and 1 more commits\n" +
+		"compare: https://github.com/scjtqs2/bot_app_github/compare/aaa...ccc"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+// TestReleaseTemplate 测试release消息
+func TestReleaseTemplate(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	payload := gjson.Parse(`{
+		"release": {
+			"tag_name": "v1.0.0", "name": "first", "prerelease": false, "body": "changelog",
+			"html_url": "https://github.com/scjtqs2/bot_app_github/releases/tag/v1.0.0",
+			"author": {"login": "scjtqs2"},
+			"assets": [{"name": "bot_app_linux_amd64.tar.gz", "size": 1572864, "browser_download_url": "https://github.com/x.tar.gz"}]
+		}
+	}`)
+	event := Event{Type: "release", Action: "published", FromUser: "scjtqs2", Owner: "scjtqs2", Repo: "bot_app_github", Tag: "v1.0.0"}
+	got, err := templates.Render(&TemplateData{Event: &event, Payload: payload})
+	if err != nil {
+		t.Fatalf("Render err %v", err)
+	}
+	want := "scjtqs2 published release v1.0.0 of scjtqs2/bot_app_github\n" +
+		"Name: first\n" +
+		"Author: scjtqs2\n" +
+		"Notes: changelog\n" +
+		"Asset: bot_app_linux_amd64.tar.gz (1.5 MB) https://github.com/x.tar.gz\n" +
+		"jump: https://github.com/scjtqs2/bot_app_github/releases/tag/v1.0.0"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	// 预发布转为正式版时只发送 released，重复的推送按 release 去重
+	for _, action := range []string{"released", "prereleased"} {
+		if !templates.Has(event.Type, action) {
+			t.Errorf("release.%s should have a template", action)
+		}
+	}
+	event.Action = "edited"
+	if templates.Has(event.Type, event.Action) {
+		t.Errorf("release.edited should not have a template")
+	}
+}
+
+// TestPullRequestTemplates 测试pull request各个action的消息
+func TestPullRequestTemplates(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	const tail = "scjtqs2/bot_app_github #7 (main<-octocat:feature)\n" +
+		"Title: add feature\n" +
+		"jump: https://github.com/scjtqs2/bot_app_github/pull/7"
+	tests := []struct {
+		action string
+		extra  string
+		want   string
+	}{
+		{action: "closed", extra: `"merged": true, "merged_by": {"login": "scjtqs2"}`, want: "scjtqs2 merged pull request " + tail},
+		{action: "closed", extra: `"merged": false`, want: "octocat closed without merging pull request " + tail},
+		{action: "reopened", want: "octocat reopened pull request " + tail},
+		{action: "ready_for_review", want: "octocat marked pull request ready for review " + tail},
+		{action: "converted_to_draft", want: "octocat converted pull request to draft " + tail},
+		{action: "review_requested", want: "octocat requested review from scjtqs2 on pull request " + tail},
+		{action: "synchronize", extra: `"commits": 3`, want: "octocat pushed to pull request (aaaaaaa..bbbbbbb, 3 commits) " + tail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			pr := `"number": 7, "title": "add feature", "html_url": "https://github.com/scjtqs2/bot_app_github/pull/7", "head": {"label": "octocat:feature"}`
+			if tt.extra != "" {
+				pr += "," + tt.extra
+			}
+			payload := gjson.Parse(`{"before": "aaaaaaaaaa", "after": "bbbbbbbbbb", "requested_reviewer": {"login": "scjtqs2"}, "pull_request": {` + pr + `}}`)
+			event := Event{Type: "pull_request", Action: tt.action, FromUser: "octocat", Owner: "octocat", Repo: "bot_app_github",
+				Branch: "feature", BaseOwner: "scjtqs2", BaseRepo: "bot_app_github", BaseBranch: "main"}
+			got, err := templates.Render(&TemplateData{Event: &event, Payload: payload})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPullRequestReviewTemplates 测试review和review comment的消息
+func TestPullRequestReviewTemplates(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	const pr = `"pull_request": {"number": 7, "title": "add feature", "html_url": "https://github.com/scjtqs2/bot_app_github/pull/7", "head": {"label": "octocat:feature"}}`
+	tests := []struct {
+		name       string
+		event      Event
+		payload    string
+		screenshot []byte
+		want       string
+	}{
+		{
+			name:    "approved",
+			event:   Event{Type: "pull_request_review", Action: "submitted"},
+			payload: `{"review": {"state": "approved", "body": "LGTM"},` + pr + `}`,
+			want: "scjtqs2 approved pull request scjtqs2/bot_app_github #7 (main<-octocat:feature)\n" +
+				"Title: add feature\n" +
+				"jump: https://github.com/scjtqs2/bot_app_github/pull/7\n" +
+				"Review: LGTM",
+		},
+		{
+			name:    "changes requested",
+			event:   Event{Type: "pull_request_review", Action: "submitted"},
+			payload: `{"review": {"state": "changes_requested", "body": ""},` + pr + `}`,
+			want: "scjtqs2 requested changes on pull request scjtqs2/bot_app_github #7 (main<-octocat:feature)\n" +
+				"Title: add feature\n" +
+				"jump: https://github.com/scjtqs2/bot_app_github/pull/7",
+		},
+		{
+			name:  "review comment",
+			event: Event{Type: "pull_request_review_comment", Action: "created"},
+			payload: `{"comment": {"path": "main.go", "line": null, "original_line": 12, "body": "typo",
+				"diff_hunk": "@@ -1,3 +1,3 @@\n a\n-b\n+c", "html_url": "https://github.com/scjtqs2/bot_app_github/pull/7#discussion_r1"},` + pr + `}`,
+			want: "scjtqs2 commented on pull request scjtqs2/bot_app_github #7\n" +
+				"Title: add feature\n" +
+				"jump: https://github.com/scjtqs2/bot_app_github/pull/7#discussion_r1\n" +
+				"File: main.go:12\n" +
+				"@@ -1,3 +1,3 @@\n a\n-b\n+c\n" +
+				"Comment: typo",
+		},
+		{
+			name:       "review comment with screenshot",
+			event:      Event{Type: "pull_request_review_comment", Action: "created"},
+			payload:    `{"comment": {"html_url": "https://github.com/scjtqs2/bot_app_github/pull/7#discussion_r1"},` + pr + `}`,
+			screenshot: []byte("png"),
+			want: "scjtqs2 commented on pull request scjtqs2/bot_app_github #7\n" +
+				"Title: add feature\n" +
+				"jump: https://github.com/scjtqs2/bot_app_github/pull/7#discussion_r1\n" +
+				imageBase64Code([]byte("png")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.FromUser = "scjtqs2"
+			tt.event.BaseOwner, tt.event.BaseRepo, tt.event.BaseBranch = "scjtqs2", "bot_app_github", "main"
+			got, err := templates.Render(&TemplateData{Event: &tt.event, Payload: gjson.Parse(tt.payload), Screenshot: tt.screenshot})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if normalizeCQ(got) != normalizeCQ(tt.want) {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRepositoryEventTemplates 测试仓库管理类event的解析和默认模板
+func TestRepositoryEventTemplates(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	const repo = `"repository": {"name": "hello", "full_name": "octocat/hello", "html_url": "https://github.com/octocat/hello", "owner": {"login": "octocat"}}, "sender": {"login": "alice"}`
+	tests := []struct {
+		eventType string
+		payload   string
+		want      string
+	}{
+		{
+			eventType: "delete",
+			payload:   `{"ref": "v1.0.0", "ref_type": "tag",` + repo + `}`,
+			want:      "alice deleted tag v1.0.0 of octocat/hello",
+		},
+		{
+			eventType: "member",
+			payload:   `{"action": "removed", "member": {"login": "bob"},` + repo + `}`,
+			want:      "alice removed collaborator bob from octocat/hello\njump: https://github.com/octocat/hello",
+		},
+		{
+			eventType: "public",
+			payload:   `{` + repo + `}`,
+			want:      "alice made octocat/hello public\njump: https://github.com/octocat/hello",
+		},
+		{
+			eventType: "repository",
+			payload:   `{"action": "renamed", "changes": {"repository": {"name": {"from": "hi"}}},` + repo + `}`,
+			want:      "alice renamed repository octocat/hello (was hi)\njump: https://github.com/octocat/hello",
+		},
+		{
+			eventType: "branch_protection_rule",
+			payload:   `{"action": "created", "rule": {"name": "main"},` + repo + `}`,
+			want:      "alice created branch protection rule main of octocat/hello\njump: https://github.com/octocat/hello/settings/branches",
+		},
+		{
+			eventType: "label",
+			payload:   `{"action": "edited", "label": {"name": "bug", "description": ""}, "changes": {"name": {"from": "defect"}},` + repo + `}`,
+			want:      "alice edited label [bug] of octocat/hello (was [defect])",
+		},
+		{
+			eventType: "milestone",
+			payload:   `{"action": "closed", "milestone": {"title": "v1.0", "open_issues": 0, "closed_issues": 4, "html_url": "https://github.com/octocat/hello/milestone/1"},` + repo + `}`,
+			want:      "alice closed milestone v1.0 of octocat/hello\nProgress: 4 closed, 0 open\njump: https://github.com/octocat/hello/milestone/1",
+		},
+		{
+			eventType: "discussion",
+			payload:   `{"action": "created", "discussion": {"number": 3, "title": "Roadmap", "body": "What next?", "category": {"name": "Ideas"}, "html_url": "https://github.com/octocat/hello/discussions/3"},` + repo + `}`,
+			want:      "alice created discussion octocat/hello #3\n[Ideas] Title: Roadmap\nBody: What next?\njump: https://github.com/octocat/hello/discussions/3",
+		},
+		{
+			eventType: "discussion_comment",
+			payload:   `{"action": "created", "discussion": {"number": 3, "title": "Roadmap"}, "comment": {"body": "More tests", "html_url": "https://github.com/octocat/hello/discussions/3#discussioncomment-1"},` + repo + `}`,
+			want:      "alice commented on discussion octocat/hello #3\nTitle: Roadmap\nComment: More tests\njump: https://github.com/octocat/hello/discussions/3#discussioncomment-1",
+		},
+		{
+			eventType: "gollum",
+			payload:   `{"pages": [{"title": "Home", "action": "edited", "html_url": "https://github.com/octocat/hello/wiki/Home"}, {"title": "FAQ", "action": "created", "html_url": "https://github.com/octocat/hello/wiki/FAQ"}],` + repo + `}`,
+			want:      "alice updated the wiki of octocat/hello\nedited Home: https://github.com/octocat/hello/wiki/Home\ncreated FAQ: https://github.com/octocat/hello/wiki/FAQ",
+		},
+		{
+			eventType: "commit_comment",
+			payload:   `{"action": "created", "comment": {"commit_id": "abc1234def", "path": "main.go", "line": 12, "body": "nice", "html_url": "https://github.com/octocat/hello/commit/abc1234def#commitcomment-1"},` + repo + `}`,
+			want:      "alice commented on commit octocat/hello@abc1234 main.go:12\nComment: nice\njump: https://github.com/octocat/hello/commit/abc1234def#commitcomment-1",
+		},
+		{
+			eventType: "security_advisory",
+			payload: `{"action": "published", "security_advisory": {"ghsa_id": "GHSA-xxxx-yyyy-zzzz", "cve_id": "CVE-2022-0001", "severity": "high", "summary": "RCE in parser",
+				"vulnerabilities": [{"package": {"ecosystem": "go", "name": "example.com/parser"}, "vulnerable_version_range": "< 1.2.3", "first_patched_version": {"identifier": "1.2.3"}}]}}`,
+			want: "Security advisory published: GHSA-xxxx-yyyy-zzzz / CVE-2022-0001 (high)\nRCE in parser\ngo/example.com/parser < 1.2.3, patched in 1.2.3\njump: https://github.com/advisories/GHSA-xxxx-yyyy-zzzz",
+		},
+		{
+			eventType: "dependabot_alert",
+			payload: `{"action": "created", "alert": {"number": 2, "html_url": "https://github.com/octocat/hello/security/dependabot/2",
+				"dependency": {"package": {"ecosystem": "npm", "name": "lodash"}, "manifest_path": "package-lock.json"},
+				"security_advisory": {"severity": "critical", "summary": "Prototype pollution"},
+				"security_vulnerability": {"vulnerable_version_range": "< 4.17.21", "first_patched_version": {"identifier": "4.17.21"}}},` + repo + `}`,
+			want: "Dependabot alert #2 created in octocat/hello (critical)\nnpm/lodash in package-lock.json: Prototype pollution\nAffected: < 4.17.21\nPatched: 4.17.21\njump: https://github.com/octocat/hello/security/dependabot/2",
+		},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			if !allowEvent(tt.eventType) {
+				t.Fatalf("allowEvent(%s) = false", tt.eventType)
+			}
+			event, err := s.parseEvent(tt.eventType, []byte(tt.payload))
+			if err != nil {
+				t.Fatalf("parseEvent err %v", err)
+			}
+			if tt.eventType != "security_advisory" && (event.Owner != "octocat" || event.Repo != "hello" || event.FromUser != "alice") {
+				t.Errorf("event = %s/%s from %s", event.Owner, event.Repo, event.FromUser)
+			}
+			got, err := templates.Render(&TemplateData{Event: event, Payload: event.Payload})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInstallationTemplates 测试github app安装类event的默认模板
+func TestInstallationTemplates(t *testing.T) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	tests := []struct {
+		eventType string
+		payload   string
+		want      string
+	}{
+		{
+			eventType: "installation",
+			payload:   `{"action": "created", "installation": {"id": 7, "app_slug": "bot", "html_url": "https://github.com/organizations/acme/settings/installations/7", "account": {"login": "acme", "type": "Organization"}}, "repositories": [{"full_name": "acme/api"}, {"full_name": "acme/web"}], "sender": {"login": "alice"}}`,
+			want:      "alice created GitHub App bot on acme (2 repositories)\njump: https://github.com/organizations/acme/settings/installations/7",
+		},
+		{
+			eventType: "installation_repositories",
+			payload:   `{"action": "removed", "installation": {"id": 7, "app_slug": "bot", "account": {"login": "acme", "type": "Organization"}}, "repositories_added": [], "repositories_removed": [{"full_name": "acme/old"}], "sender": {"login": "alice"}}`,
+			want:      "alice removed repositories from GitHub App bot on acme\n- acme/old",
+		},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			event, err := s.parseEvent(tt.eventType, []byte(tt.payload))
+			if err != nil {
+				t.Fatalf("parseEvent err %v", err)
+			}
+			got, err := templates.Render(&TemplateData{Event: event, Payload: event.Payload})
+			if err != nil {
+				t.Fatalf("Render err %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"strconv"
+	"sync"
+)
+
+// maxThreads 最多记录的消息串数，超过时丢弃最早的
+const maxThreads = 500
+
+// ThreadKey 需要串成一条回复链的event的key，如同一次部署的 deployment 和 deployment_status，其他event为空
+func (e *Event) ThreadKey() string {
+	switch e.Type {
+	case "deployment", "deployment_status":
+		return "deployment:" + e.FullName() + "#" + strconv.FormatInt(e.Payload.Get("deployment.id").Int(), 10)
+	}
+	return ""
+}
+
+// thread 一条消息串
+type thread struct {
+	states  []string         // 经历过的状态
+	replies map[string]int64 // 推送目标 -> 第一条消息的id，之后的消息回复它
+}
+
+// Threads 记录消息串的状态变化和第一条消息的id，后续消息以回复的形式推送。
+// 只保存在内存中，最多保留 maxThreads 条
+type Threads struct {
+	mu    sync.Mutex
+	items map[string]*thread
+	order []string // 按创建顺序排列的key
+}
+
+// NewThreads 初始化
+func NewThreads() *Threads {
+	return &Threads{items: make(map[string]*thread)}
+}
+
+// get 获取或创建消息串
+func (t *Threads) get(key string) *thread {
+	th, ok := t.items[key]
+	if ok {
+		return th
+	}
+	if len(t.order) >= maxThreads {
+		delete(t.items, t.order[0])
+		t.order = t.order[1:]
+	}
+	th = &thread{replies: make(map[string]int64)}
+	t.items[key] = th
+	t.order = append(t.order, key)
+	return th
+}
+
+// Transition 记录新的状态，和上一个状态相同时不重复记录，返回经历过的所有状态
+func (t *Threads) Transition(key, state string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	th := t.get(key)
+	if n := len(th.states); n == 0 || th.states[n-1] != state {
+		th.states = append(th.states, state)
+	}
+	return append([]string(nil), th.states...)
+}
+
+// ReplyTo 推送目标在消息串中的第一条消息id，没有时返回0
+func (t *Threads) ReplyTo(key, target string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if th, ok := t.items[key]; ok {
+		return th.replies[target]
+	}
+	return 0
+}
+
+// SetReply 记录推送目标在消息串中的第一条消息id，已经记录过时不覆盖
+func (t *Threads) SetReply(key, target string, id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	th := t.get(key)
+	if _, ok := th.replies[target]; !ok && id != 0 {
+		th.replies[target] = id
+	}
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"testing"
+)
+
+const deploymentPayload = `"deployment":{"id":42,"ref":"main","sha":"abc1234def","environment":"production","creator":{"login":"octocat"}},"repository":{"name":"hello","full_name":"octocat/hello","owner":{"login":"octocat"}},"sender":{"login":"octocat"}`
+
+// TestDeploymentThread 测试同一次部署的状态变化和回复链
+func TestDeploymentThread(t *testing.T) {
+	tpl, err := NewTemplates("")
+	if err != nil {
+		t.Fatalf("NewTemplates err %v", err)
+	}
+	s := NewServer()
+	threads := NewThreads()
+
+	created, err := s.parseEvent("deployment", []byte(`{"action":"created",`+deploymentPayload+`}`))
+	if err != nil {
+		t.Fatalf("parseEvent err %v", err)
+	}
+	key := created.ThreadKey()
+	if key != "deployment:octocat/hello#42" || created.Branch != "main" {
+		t.Fatalf("ThreadKey() = %s, branch %s", key, created.Branch)
+	}
+	got, _ := tpl.Render(&TemplateData{Event: created, Payload: created.Payload})
+	if want := "octocat started deployment #42 of octocat/hello:main @abc1234 to production"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	if threads.ReplyTo(key, "group:1") != 0 {
+		t.Errorf("ReplyTo() before first message should be 0")
+	}
+	threads.SetReply(key, "group:1", 100)
+	threads.SetReply(key, "group:1", 200)
+
+	var states []string
+	for _, state := range []string{"queued", "in_progress", "in_progress", "success"} {
+		event, err := s.parseEvent("deployment_status", []byte(`{"action":"created","deployment_status":{"state":"`+state+`","environment":"production","environment_url":"https://hello.example.com"},`+deploymentPayload+`}`))
+		if err != nil {
+			t.Fatalf("parseEvent err %v", err)
+		}
+		if event.ThreadKey() != key {
+			t.Fatalf("deployment_status ThreadKey() = %s, want %s", event.ThreadKey(), key)
+		}
+		states = threads.Transition(key, state)
+		got, _ = tpl.Render(&TemplateData{Event: event, Payload: event.Payload, States: states})
+	}
+	want := "Deployment #42 of octocat/hello to production: queued → in_progress → success\nref: main @abc1234 by octocat\nurl: https://hello.example.com"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	if id := threads.ReplyTo(key, "group:1"); id != 100 {
+		t.Errorf("ReplyTo() = %d, want 100", id)
+	}
+	if id := threads.ReplyTo(key, "qq:1"); id != 0 {
+		t.Errorf("ReplyTo() other target = %d, want 0", id)
+	}
+}